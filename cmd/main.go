@@ -1,4 +1,190 @@
 package main
 
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/moremorefun/mcommon"
+)
+
 func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	var err error
+	switch os.Args[1] {
+	case "gen-model":
+		err = genModel(os.Args[2:])
+	case "migrate":
+		err = migrate(os.Args[2:])
+	case "snapshot":
+		err = snapshot(os.Args[2:])
+	case "drift":
+		err = drift(os.Args[2:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "err: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+// usage 显示帮助
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [flags]\n\ncommands:\n  gen-model  generate go model structs from mysql schema\n  migrate    run versioned sql migrations: up, down, redo, status\n  snapshot   export normalized schema of all tables to a directory\n  drift      compare a schema snapshot directory with the live database\n", os.Args[0])
+}
+
+// genModel 根据数据库或sql文件生成结构体
+func genModel(args []string) error {
+	fs := flag.NewFlagSet("gen-model", flag.ExitOnError)
+	dsn := fs.String("dsn", "", "mysql dsn, read schema from live database")
+	sqlFile := fs.String("sql", "", "sql file, read schema from CREATE TABLE statements")
+	pkg := fs.String("pkg", "model", "package name")
+	tables := fs.String("tables", "", "comma separated table names, default all")
+	out := fs.String("out", "", "output file, default stdout")
+	_ = fs.Parse(args)
+
+	opts := mcommon.DbGenOptions{
+		PackageName: *pkg,
+	}
+	if *tables != "" {
+		opts.Tables = strings.Split(*tables, ",")
+	}
+	var code []byte
+	var err error
+	switch {
+	case *sqlFile != "":
+		code, err = mcommon.DbGenModelFromFile(*sqlFile, &opts)
+	case *dsn != "":
+		db := mcommon.DbCreate(*dsn, false)
+		defer func() {
+			_ = db.Close()
+		}()
+		code, err = mcommon.DbGenModelFromDb(context.Background(), db, &opts)
+	default:
+		fs.Usage()
+		return fmt.Errorf("need -dsn or -sql")
+	}
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		_, err = os.Stdout.Write(code)
+		return err
+	}
+	return ioutil.WriteFile(*out, code, 0644)
+}
+
+// migrate 执行版本化迁移
+func migrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dsn := fs.String("dsn", "", "mysql dsn")
+	dir := fs.String("dir", "migrations", "migration files directory")
+	table := fs.String("table", mcommon.DbMigrateTableDefault, "migration records table")
+	n := fs.Int("n", 1, "number of migrations to roll back for down")
+	_ = fs.Parse(args)
+
+	if *dsn == "" || fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("usage: migrate -dsn <dsn> [flags] up|down|redo|status")
+	}
+	db := mcommon.DbCreate(*dsn, false)
+	defer func() {
+		_ = db.Close()
+	}()
+	ctx := context.Background()
+	m := mcommon.DbNewMigrator(db, *dir).WithTable(*table)
+	switch fs.Arg(0) {
+	case "up":
+		count, err := m.Up(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("applied %d migrations\n", count)
+	case "down":
+		count, err := m.Down(ctx, *n)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("rolled back %d migrations\n", count)
+	case "redo":
+		return m.Redo(ctx)
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			state := "pending"
+			switch {
+			case status.Missing:
+				state = "missing"
+			case status.Dirty:
+				state = "dirty"
+			case status.Applied:
+				state = "applied"
+			}
+			fmt.Printf("%-8s %d %s\n", state, status.Version, status.Name)
+		}
+	default:
+		return fmt.Errorf("unknown migrate action: %s", fs.Arg(0))
+	}
+	return nil
+}
+
+// snapshot 导出数据库表结构快照
+func snapshot(args []string) error {
+	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	dsn := fs.String("dsn", "", "mysql dsn")
+	dir := fs.String("dir", "schema", "snapshot directory")
+	_ = fs.Parse(args)
+
+	if *dsn == "" {
+		fs.Usage()
+		return fmt.Errorf("need -dsn")
+	}
+	db := mcommon.DbCreate(*dsn, false)
+	defer func() {
+		_ = db.Close()
+	}()
+	s, err := mcommon.DbSnapshotExport(context.Background(), db, *dir, nil)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("exported %d tables to %s\n", len(s), *dir)
+	return nil
+}
+
+// drift 比较快照和数据库, 有差异时返回错误
+func drift(args []string) error {
+	fs := flag.NewFlagSet("drift", flag.ExitOnError)
+	dsn := fs.String("dsn", "", "mysql dsn")
+	dir := fs.String("dir", "schema", "snapshot directory")
+	_ = fs.Parse(args)
+
+	if *dsn == "" {
+		fs.Usage()
+		return fmt.Errorf("need -dsn")
+	}
+	db := mcommon.DbCreate(*dsn, false)
+	defer func() {
+		_ = db.Close()
+	}()
+	d, err := mcommon.DbSnapshotDriftDb(context.Background(), db, *dir, nil)
+	if err != nil {
+		return err
+	}
+	if d.HasDrift() {
+		fmt.Print(d.String())
+		return fmt.Errorf("schema drift in %d tables", len(d.Tables))
+	}
+	fmt.Println("no schema drift")
+	return nil
 }
@@ -0,0 +1,447 @@
+package mcommon
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// dbFakeDriverName 测试驱动名
+const dbFakeDriverName = "mcommon_fake"
+
+// dbFakeRegisterOnce 注册测试驱动
+var dbFakeRegisterOnce sync.Once
+
+// dbFakes 测试数据库, dsn -> 对象
+var dbFakes sync.Map
+
+// dbFakeSeq 测试数据库序号
+var dbFakeSeq int64
+
+// dbFakeSeqLock 测试数据库序号锁
+var dbFakeSeqLock sync.Mutex
+
+// dbFakeSavepointReg 保存点语句, 没有预设时也执行成功
+var dbFakeSavepointReg = regexp.MustCompile(`(?i)^\s*(SAVEPOINT|RELEASE\s+SAVEPOINT|ROLLBACK\s+TO\s+SAVEPOINT)\s`)
+
+// DbFakeRecord 执行记录
+type DbFakeRecord struct {
+	Query string
+	Args  []interface{}
+}
+
+// DbFakeExpect 预设的执行结果
+type DbFakeExpect struct {
+	pattern      *regexp.Regexp
+	args         []interface{}
+	hasArgs      bool
+	isOnce       bool
+	isUsed       bool
+	columns      []string
+	types        []string
+	rows         [][]interface{}
+	lastID       int64
+	rowsAffected int64
+	err          error
+}
+
+// DbFake 测试用的数据库, 记录执行的sql并返回预设结果, 不需要真实的mysql
+// DB 为使用测试驱动的 *sqlx.DB, 可以作为 DbExeAble 使用, 也支持 DbTransaction
+type DbFake struct {
+	DB *sqlx.DB
+
+	mu      sync.Mutex
+	expects []*DbFakeExpect
+	records []DbFakeRecord
+}
+
+// DbNewFake 创建测试数据库
+func DbNewFake() *DbFake {
+	dbFakeRegisterOnce.Do(func() {
+		sql.Register(dbFakeDriverName, dbFakeDriver{})
+	})
+	dbFakeSeqLock.Lock()
+	dbFakeSeq++
+	dsn := "fake_" + strconv.FormatInt(dbFakeSeq, 10)
+	dbFakeSeqLock.Unlock()
+
+	f := &DbFake{}
+	dbFakes.Store(dsn, f)
+	db, err := sql.Open(dbFakeDriverName, dsn)
+	if err != nil {
+		Log.Fatalf("fake db open error: %s", err.Error())
+		return nil
+	}
+	// 使用mysql的参数格式
+	f.DB = sqlx.NewDb(db, "mysql")
+	return f
+}
+
+// Close 关闭
+func (f *DbFake) Close() error {
+	err := f.DB.Close()
+	dbFakes.Range(func(k, v interface{}) bool {
+		if v == f {
+			dbFakes.Delete(k)
+		}
+		return true
+	})
+	return err
+}
+
+// Expect 预设匹配正则 pattern 的sql的执行结果, 先添加的优先匹配
+// 没有设置结果时查询返回空结果, 执行返回0
+// 没有预设的保存点语句 (嵌套 DbTransaction) 直接执行成功
+func (f *DbFake) Expect(pattern string) *DbFakeExpect {
+	e := &DbFakeExpect{
+		pattern: regexp.MustCompile(pattern),
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.expects = append(f.expects, e)
+	return e
+}
+
+// WithArgs 只匹配参数相同的sql
+func (e *DbFakeExpect) WithArgs(args ...interface{}) *DbFakeExpect {
+	e.args = dbFakeValues(args)
+	e.hasArgs = true
+	return e
+}
+
+// Once 只匹配一次
+func (e *DbFakeExpect) Once() *DbFakeExpect {
+	e.isOnce = true
+	return e
+}
+
+// WillReturnRows 查询返回的列和行
+func (e *DbFakeExpect) WillReturnRows(columns []string, rows ...[]interface{}) *DbFakeExpect {
+	e.columns = columns
+	e.rows = rows
+	return e
+}
+
+// WithColumnTypes 指定返回列的数据库类型, 没有指定的列根据第一个非空值推断
+func (e *DbFakeExpect) WithColumnTypes(types ...string) *DbFakeExpect {
+	e.types = types
+	return e
+}
+
+// WillReturnResult 执行返回的lastID和影响行数
+func (e *DbFakeExpect) WillReturnResult(lastID int64, rowsAffected int64) *DbFakeExpect {
+	e.lastID = lastID
+	e.rowsAffected = rowsAffected
+	return e
+}
+
+// WillReturnError 执行返回错误
+func (e *DbFakeExpect) WillReturnError(err error) *DbFakeExpect {
+	e.err = err
+	return e
+}
+
+// Records 获取执行记录, 事物的开始, 提交和回滚记录为 BEGIN, COMMIT, ROLLBACK
+func (f *DbFake) Records() []DbFakeRecord {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]DbFakeRecord{}, f.records...)
+}
+
+// Reset 清空预设结果和执行记录
+func (f *DbFake) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.expects = nil
+	f.records = nil
+}
+
+// record 记录执行的sql并查找预设结果
+func (f *DbFake) record(query string, args []driver.NamedValue) (*DbFakeExpect, error) {
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.records = append(f.records, DbFakeRecord{
+		Query: query,
+		Args:  values,
+	})
+	for _, e := range f.expects {
+		if e.isOnce && e.isUsed {
+			continue
+		}
+		if !e.pattern.MatchString(query) {
+			continue
+		}
+		if e.hasArgs && !reflect.DeepEqual(e.args, dbFakeValues(values)) {
+			continue
+		}
+		e.isUsed = true
+		return e, nil
+	}
+	if dbFakeSavepointReg.MatchString(query) {
+		return &DbFakeExpect{}, nil
+	}
+	return nil, fmt.Errorf("fake db no expect: %s %v", query, values)
+}
+
+// recordOnly 只记录, 用于事物操作
+func (f *DbFake) recordOnly(query string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.records = append(f.records, DbFakeRecord{Query: query})
+}
+
+// dbFakeValues 转换为驱动支持的值, 用于比较参数
+func dbFakeValues(args []interface{}) []interface{} {
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		v, err := driver.DefaultParameterConverter.ConvertValue(arg)
+		if err != nil {
+			v = arg
+		}
+		values[i] = v
+	}
+	return values
+}
+
+// dbFakeDriver 测试驱动
+type dbFakeDriver struct{}
+
+// Open 打开链接
+func (dbFakeDriver) Open(dsn string) (driver.Conn, error) {
+	v, ok := dbFakes.Load(dsn)
+	if !ok {
+		return nil, fmt.Errorf("fake db not found: %s", dsn)
+	}
+	return &dbFakeConn{f: v.(*DbFake)}, nil
+}
+
+// dbFakeConn 测试链接
+type dbFakeConn struct {
+	f *DbFake
+}
+
+// Prepare 预处理
+func (c *dbFakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &dbFakeStmt{c: c, query: query}, nil
+}
+
+// Close 关闭
+func (c *dbFakeConn) Close() error {
+	return nil
+}
+
+// Begin 开始事物
+func (c *dbFakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// BeginTx 开始事物
+func (c *dbFakeConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.f.recordOnly("BEGIN")
+	return &dbFakeTx{c: c}, nil
+}
+
+// CheckNamedValue 接受所有参数类型
+func (c *dbFakeConn) CheckNamedValue(nv *driver.NamedValue) error {
+	v, err := driver.DefaultParameterConverter.ConvertValue(nv.Value)
+	if err == nil {
+		nv.Value = v
+	}
+	return nil
+}
+
+// ExecContext 执行
+func (c *dbFakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, err := c.f.record(query, args)
+	if err != nil {
+		return nil, err
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+	return dbFakeResult{lastID: e.lastID, rowsAffected: e.rowsAffected}, nil
+}
+
+// QueryContext 查询
+func (c *dbFakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	e, err := c.f.record(query, args)
+	if err != nil {
+		return nil, err
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+	rows := &dbFakeRows{
+		columns: e.columns,
+		types:   make([]string, len(e.columns)),
+		rows:    make([][]driver.Value, len(e.rows)),
+	}
+	copy(rows.types, e.types)
+	for i, row := range e.rows {
+		if len(row) != len(e.columns) {
+			return nil, fmt.Errorf("fake db row %d len %d != columns len %d", i, len(row), len(e.columns))
+		}
+		values := make([]driver.Value, len(row))
+		for j, v := range dbFakeValues(row) {
+			if rows.types[j] == "" {
+				rows.types[j] = dbFakeTypeName(v)
+			}
+			// 和mysql驱动一样, bool 返回为 0 或 1
+			if b, ok := v.(bool); ok {
+				v = int64(0)
+				if b {
+					v = int64(1)
+				}
+			}
+			values[j] = v
+		}
+		rows.rows[i] = values
+	}
+	return rows, nil
+}
+
+// dbFakeTx 测试事物
+type dbFakeTx struct {
+	c *dbFakeConn
+}
+
+// Commit 提交
+func (t *dbFakeTx) Commit() error {
+	t.c.f.recordOnly("COMMIT")
+	return nil
+}
+
+// Rollback 回滚
+func (t *dbFakeTx) Rollback() error {
+	t.c.f.recordOnly("ROLLBACK")
+	return nil
+}
+
+// dbFakeStmt 测试预处理语句
+type dbFakeStmt struct {
+	c     *dbFakeConn
+	query string
+}
+
+// Close 关闭
+func (s *dbFakeStmt) Close() error {
+	return nil
+}
+
+// NumInput 参数个数, 不检查
+func (s *dbFakeStmt) NumInput() int {
+	return -1
+}
+
+// Exec 执行
+func (s *dbFakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.c.ExecContext(context.Background(), s.query, dbFakeNamedValues(args))
+}
+
+// Query 查询
+func (s *dbFakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.c.QueryContext(context.Background(), s.query, dbFakeNamedValues(args))
+}
+
+// ExecContext 执行
+func (s *dbFakeStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.c.ExecContext(ctx, s.query, args)
+}
+
+// QueryContext 查询
+func (s *dbFakeStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.c.QueryContext(ctx, s.query, args)
+}
+
+// dbFakeNamedValues 转换参数
+func dbFakeNamedValues(args []driver.Value) []driver.NamedValue {
+	namedValues := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		namedValues[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return namedValues
+}
+
+// dbFakeResult 测试执行结果
+type dbFakeResult struct {
+	lastID       int64
+	rowsAffected int64
+}
+
+// LastInsertId 插入id
+func (r dbFakeResult) LastInsertId() (int64, error) {
+	return r.lastID, nil
+}
+
+// RowsAffected 影响行数
+func (r dbFakeResult) RowsAffected() (int64, error) {
+	return r.rowsAffected, nil
+}
+
+// dbFakeRows 测试结果集
+type dbFakeRows struct {
+	columns []string
+	types   []string
+	rows    [][]driver.Value
+	index   int
+}
+
+// Columns 列名
+func (r *dbFakeRows) Columns() []string {
+	return r.columns
+}
+
+// Close 关闭
+func (r *dbFakeRows) Close() error {
+	return nil
+}
+
+// Next 下一行
+func (r *dbFakeRows) Next(dest []driver.Value) error {
+	if r.index >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.index])
+	r.index++
+	return nil
+}
+
+// ColumnTypeDatabaseTypeName 根据第一个非空值推断数据库类型
+func (r *dbFakeRows) ColumnTypeDatabaseTypeName(index int) string {
+	if r.types[index] == "" {
+		return "VARCHAR"
+	}
+	return r.types[index]
+}
+
+// dbFakeTypeName 值对应的数据库类型, nil 返回空字符串
+func dbFakeTypeName(v driver.Value) string {
+	switch v.(type) {
+	case nil:
+		return ""
+	case bool:
+		return "TINYINT"
+	case int64:
+		return "BIGINT"
+	case float64:
+		return "DOUBLE"
+	case []byte:
+		return "BLOB"
+	case time.Time:
+		return "DATETIME"
+	}
+	return "VARCHAR"
+}
@@ -0,0 +1,163 @@
+package mcommon
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDbFakeExpect(t *testing.T) {
+	errFake := errors.New("fake error")
+	tests := []struct {
+		name    string
+		setup   func(f *DbFake)
+		query   string
+		args    []interface{}
+		wantErr error
+		wantN   int64
+		noMatch bool
+	}{
+		{
+			name: "match",
+			setup: func(f *DbFake) {
+				f.Expect(`^UPDATE t`).WillReturnResult(0, 2)
+			},
+			query: "UPDATE t SET a=1",
+			wantN: 2,
+		},
+		{
+			name: "args",
+			setup: func(f *DbFake) {
+				f.Expect(`^UPDATE t`).WithArgs(int64(2)).WillReturnResult(0, 2)
+				f.Expect(`^UPDATE t`).WithArgs(1).WillReturnResult(0, 1)
+			},
+			query: "UPDATE t SET a=?",
+			args:  []interface{}{1},
+			wantN: 1,
+		},
+		{
+			name: "error",
+			setup: func(f *DbFake) {
+				f.Expect(`^UPDATE t`).WillReturnError(errFake)
+			},
+			query:   "UPDATE t SET a=1",
+			wantErr: errFake,
+		},
+		{
+			name:    "no expect",
+			setup:   func(f *DbFake) {},
+			query:   "UPDATE t SET a=1",
+			noMatch: true,
+		},
+		{
+			name:  "savepoint",
+			setup: func(f *DbFake) {},
+			query: "SAVEPOINT sp_1",
+		},
+		{
+			name:  "release savepoint",
+			setup: func(f *DbFake) {},
+			query: "RELEASE SAVEPOINT sp_1",
+		},
+		{
+			name:  "rollback to savepoint",
+			setup: func(f *DbFake) {},
+			query: "ROLLBACK TO SAVEPOINT sp_1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := DbNewFake()
+			defer f.Close()
+			tt.setup(f)
+			ret, err := f.DB.ExecContext(context.Background(), tt.query, tt.args...)
+			switch {
+			case tt.noMatch:
+				if err == nil {
+					t.Fatalf("want no expect error")
+				}
+				return
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			case err != nil:
+				t.Fatalf("exec error: %s", err.Error())
+			}
+			n, _ := ret.RowsAffected()
+			if n != tt.wantN {
+				t.Fatalf("rows affected = %d, want %d", n, tt.wantN)
+			}
+		})
+	}
+}
+
+func TestDbFakeOnce(t *testing.T) {
+	f := DbNewFake()
+	defer f.Close()
+	f.Expect(`^DELETE`).Once().WillReturnResult(0, 1)
+	f.Expect(`^DELETE`).WillReturnResult(0, 0)
+	for _, want := range []int64{1, 0, 0} {
+		ret, err := f.DB.Exec("DELETE FROM t")
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, _ := ret.RowsAffected()
+		if n != want {
+			t.Fatalf("rows affected = %d, want %d", n, want)
+		}
+	}
+}
+
+func TestDbFakeRowsTypes(t *testing.T) {
+	f := DbNewFake()
+	defer f.Close()
+	f.Expect(`^SELECT`).WillReturnRows(
+		[]string{"id", "name", "ok", "price", "empty"},
+		[]interface{}{1, "a", true, 1.5, nil},
+		[]interface{}{2, "b", false, 2.5, nil},
+	)
+	rows, err := DbNamedRowsContent(context.Background(), f.DB, "SELECT id, name, ok, price, empty FROM t", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string]interface{}{
+		{"id": int64(1), "name": "a", "ok": int64(1), "price": 1.5, "empty": nil},
+		{"id": int64(2), "name": "b", "ok": int64(0), "price": 2.5, "empty": nil},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("rows len = %d, want %d", len(rows), len(want))
+	}
+	for i := range want {
+		if !reflect.DeepEqual(map[string]interface{}(rows[i]), want[i]) {
+			t.Fatalf("row %d = %#v, want %#v", i, rows[i], want[i])
+		}
+	}
+}
+
+func TestDbFakeRecords(t *testing.T) {
+	f := DbNewFake()
+	defer f.Close()
+	f.Expect(`^INSERT`).WillReturnResult(3, 1)
+	err := DbTransaction(context.Background(), f.DB, func(tx DbExeAble) error {
+		_, err := tx.Exec("INSERT INTO t (a) VALUES (?)", 1)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var queries []string
+	for _, r := range f.Records() {
+		queries = append(queries, r.Query)
+	}
+	want := []string{"BEGIN", "INSERT INTO t (a) VALUES (?)", "COMMIT"}
+	if !reflect.DeepEqual(queries, want) {
+		t.Fatalf("records = %v, want %v", queries, want)
+	}
+	f.Reset()
+	if len(f.Records()) != 0 {
+		t.Fatalf("records not reset")
+	}
+}
@@ -0,0 +1,251 @@
+package mcommon
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/schemalex/schemalex"
+	"github.com/schemalex/schemalex/model"
+)
+
+// DbGenOptions 结构体生成配置
+type DbGenOptions struct {
+	// PackageName 包名, 默认model
+	PackageName string
+	// Tables 需要生成的表, 为空时生成全部
+	Tables []string
+}
+
+// dbGenInitialisms 字段名中需要全部大写的缩写
+var dbGenInitialisms = map[string]bool{
+	"ID":   true,
+	"IP":   true,
+	"URL":  true,
+	"URI":  true,
+	"API":  true,
+	"UID":  true,
+	"UUID": true,
+	"SQL":  true,
+	"JSON": true,
+	"HTTP": true,
+}
+
+// DbGenModelFromFile 根据sql文件生成结构体代码
+func DbGenModelFromFile(sqlFilePath string, opts *DbGenOptions) ([]byte, error) {
+	content, err := ioutil.ReadFile(sqlFilePath)
+	if err != nil {
+		return nil, err
+	}
+	return DbGenModelFromSQL(string(content), opts)
+}
+
+// DbGenModelFromDb 根据数据库中的表生成结构体代码
+func DbGenModelFromDb(ctx context.Context, tx DbExeAble, opts *DbGenOptions) ([]byte, error) {
+	var tableNames []string
+	if opts != nil {
+		tableNames = opts.Tables
+	}
+	if len(tableNames) == 0 {
+		var err error
+		tableNames, err = dbShowTables(ctx, tx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var dbSQLs []string
+	for _, tableName := range tableNames {
+		tableSQL, ok, err := dbShowCreateTable(ctx, tx, tableName)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("no table: %s", tableName)
+		}
+		dbSQLs = append(dbSQLs, tableSQL+";")
+	}
+	return DbGenModelFromSQL(strings.Join(dbSQLs, "\n"), opts)
+}
+
+// DbGenModelFromSQL 根据建表语句生成结构体代码
+// 每个表生成表名常量, 列名常量和带db/json标签的结构体, 可为空的列使用sql.Null类型
+func DbGenModelFromSQL(sqlStr string, opts *DbGenOptions) ([]byte, error) {
+	var o DbGenOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.PackageName == "" {
+		o.PackageName = "model"
+	}
+	stmts, err := schemalex.New().ParseString(sqlStr)
+	if err != nil {
+		return nil, err
+	}
+	var tables []model.Table
+	for _, stmt := range stmts {
+		table, ok := stmt.(model.Table)
+		if !ok {
+			continue
+		}
+		if len(o.Tables) > 0 && !IsStringInSlice(o.Tables, table.Name()) {
+			continue
+		}
+		tables = append(tables, table)
+	}
+	sort.Slice(tables, func(i, j int) bool {
+		return tables[i].Name() < tables[j].Name()
+	})
+
+	imports := map[string]bool{}
+	var body bytes.Buffer
+	for _, table := range tables {
+		err := dbGenTable(&body, table, imports)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("// Code generated by mcommon. DO NOT EDIT.\n\n")
+	buf.WriteString("package ")
+	buf.WriteString(o.PackageName)
+	buf.WriteString("\n\n")
+	if len(imports) > 0 {
+		var importNames []string
+		for importName := range imports {
+			importNames = append(importNames, importName)
+		}
+		sort.Strings(importNames)
+		buf.WriteString("import (\n")
+		for _, importName := range importNames {
+			buf.WriteString(strconv.Quote(importName))
+			buf.WriteString("\n")
+		}
+		buf.WriteString(")\n\n")
+	}
+	buf.Write(body.Bytes())
+	return format.Source(buf.Bytes())
+}
+
+// dbGenTable 生成单个表的代码
+func dbGenTable(buf *bytes.Buffer, table model.Table, imports map[string]bool) error {
+	tableName := table.Name()
+	structName := DbGenGoName(tableName)
+
+	buf.WriteString(fmt.Sprintf("// Table%s 表名\n", structName))
+	buf.WriteString(fmt.Sprintf("const Table%s = %s\n\n", structName, strconv.Quote(tableName)))
+
+	var columns []model.TableColumn
+	for column := range table.Columns() {
+		columns = append(columns, column)
+	}
+	primaryColumns := map[string]bool{}
+	for index := range table.Indexes() {
+		if !index.IsPrimaryKey() {
+			continue
+		}
+		for column := range index.Columns() {
+			primaryColumns[column.Name()] = true
+		}
+	}
+
+	buf.WriteString(fmt.Sprintf("// %s 列名\n", structName))
+	buf.WriteString("const (\n")
+	for _, column := range columns {
+		buf.WriteString(fmt.Sprintf("%sCol%s = %s\n", structName, DbGenGoName(column.Name()), strconv.Quote(column.Name())))
+	}
+	buf.WriteString(")\n\n")
+
+	buf.WriteString(fmt.Sprintf("// %s %s\n", structName, tableName))
+	buf.WriteString(fmt.Sprintf("type %s struct {\n", structName))
+	for _, column := range columns {
+		isNullable := column.NullState() != model.NullStateNotNull &&
+			!column.IsPrimary() &&
+			!primaryColumns[column.Name()]
+		goType, importName := dbGenGoType(column, isNullable)
+		if importName != "" {
+			imports[importName] = true
+		}
+		if column.HasComment() {
+			buf.WriteString("// ")
+			buf.WriteString(strings.ReplaceAll(column.Comment(), "\n", " "))
+			buf.WriteString("\n")
+		}
+		buf.WriteString(fmt.Sprintf("%s %s `db:\"%s\" json:\"%s\"`\n", DbGenGoName(column.Name()), goType, column.Name(), column.Name()))
+	}
+	buf.WriteString("}\n\n")
+	return nil
+}
+
+// dbGenGoType 获取列对应的go类型和需要导入的包
+func dbGenGoType(column model.TableColumn, isNullable bool) (string, string) {
+	typ := column.Type().SynonymType()
+	dbType := typ.String()
+	if column.IsUnsigned() {
+		dbType = "UNSIGNED " + dbType
+	}
+	goType, ok := MysqlTypeToGoMap[dbType]
+	if !ok {
+		goType = MySqlGoTypeString
+	}
+	if typ == model.ColumnTypeTime {
+		// TIME 为时长, 驱动无法转换为 time.Time
+		goType = MySqlGoTypeString
+	}
+	switch goType {
+	case MySqlGoTypeInt64:
+		if isNullable {
+			return "sql.NullInt64", "database/sql"
+		}
+		return "int64", ""
+	case MySqlGoTypeUint64:
+		if isNullable {
+			return "*uint64", ""
+		}
+		return "uint64", ""
+	case MySqlGoTypeBytes:
+		return "[]byte", ""
+	case MySqlGoTypeFloat64:
+		if isNullable {
+			return "sql.NullFloat64", "database/sql"
+		}
+		return "float64", ""
+	case MySqlGoTypeTime:
+		if isNullable {
+			return "sql.NullTime", "database/sql"
+		}
+		return "time.Time", "time"
+	default:
+		if isNullable {
+			return "sql.NullString", "database/sql"
+		}
+		return "string", ""
+	}
+}
+
+// DbGenGoName 下划线名称转换为go的驼峰名称
+func DbGenGoName(name string) string {
+	parts := strings.FieldsFunc(name, func(r rune) bool {
+		return r == '_' || r == '-' || r == ' ' || r == '.'
+	})
+	var buf strings.Builder
+	for _, part := range parts {
+		upper := strings.ToUpper(part)
+		if dbGenInitialisms[upper] {
+			buf.WriteString(upper)
+			continue
+		}
+		buf.WriteString(strings.ToUpper(part[:1]))
+		buf.WriteString(part[1:])
+	}
+	goName := buf.String()
+	if goName == "" || (goName[0] >= '0' && goName[0] <= '9') {
+		goName = "T" + goName
+	}
+	return goName
+}
@@ -0,0 +1,88 @@
+package mcommon
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDbGenGoName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"user_id", "UserID"},
+		{"t_order", "TOrder"},
+		{"api_url", "APIURL"},
+		{"name", "Name"},
+		{"1st", "T1st"},
+		{"", "T"},
+	}
+	for _, tt := range tests {
+		got := DbGenGoName(tt.name)
+		if got != tt.want {
+			t.Errorf("DbGenGoName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDbGenModelFromSQL(t *testing.T) {
+	sqlStr := "CREATE TABLE `t_user` (\n" +
+		"  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,\n" +
+		"  `name` varchar(32) NOT NULL DEFAULT '' COMMENT 'user name',\n" +
+		"  `age` int(11) DEFAULT NULL,\n" +
+		"  `price` decimal(10,2) NOT NULL,\n" +
+		"  `created_at` datetime NOT NULL,\n" +
+		"  `avatar` blob,\n" +
+		"  PRIMARY KEY (`id`)\n" +
+		") ENGINE=InnoDB;\n" +
+		"CREATE TABLE `t_other` (`id` int(11) NOT NULL, PRIMARY KEY (`id`));"
+	tests := []struct {
+		name    string
+		opts    *DbGenOptions
+		want    []string
+		notWant []string
+	}{
+		{
+			name: "all",
+			want: []string{
+				"package model",
+				`"database/sql"`,
+				`"time"`,
+				"const TableTUser = \"t_user\"",
+				"TUserColCreatedAt = \"created_at\"",
+				"ID uint64",
+				"// user name",
+				"Name      string",
+				"Age       sql.NullInt64",
+				"Price     string",
+				"CreatedAt time.Time",
+				"Avatar    []byte",
+				"type TOther struct",
+			},
+		},
+		{
+			name:    "tables",
+			opts:    &DbGenOptions{PackageName: "db", Tables: []string{"t_other"}},
+			want:    []string{"package db", "type TOther struct"},
+			notWant: []string{"TUser", "import"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := DbGenModelFromSQL(sqlStr, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range tt.want {
+				if !strings.Contains(string(code), s) {
+					t.Errorf("code missing %q:\n%s", s, code)
+				}
+			}
+			for _, s := range tt.notWant {
+				if strings.Contains(string(code), s) {
+					t.Errorf("code contains %q:\n%s", s, code)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,528 @@
+package mcommon
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+)
+
+// DbMigrateTableDefault 默认的迁移记录表
+const DbMigrateTableDefault = "schema_migrations"
+
+// dbMigrateFileReg 迁移文件名, 如 0001_create_user.up.sql
+var dbMigrateFileReg = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// DbMigration 迁移文件
+type DbMigration struct {
+	Version  int64
+	Name     string
+	UpPath   string
+	DownPath string
+}
+
+// DbMigrationStatus 迁移状态
+type DbMigrationStatus struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt int64
+	// Missing 已执行但文件不存在
+	Missing bool
+	// Dirty 已执行但文件校验和不一致
+	Dirty bool
+}
+
+// dbMigrationRecord 迁移记录
+type dbMigrationRecord struct {
+	Version   int64  `db:"version"`
+	Name      string `db:"name"`
+	Checksum  string `db:"checksum"`
+	AppliedAt int64  `db:"applied_at"`
+}
+
+// DbMigrator 版本化迁移
+type DbMigrator struct {
+	db          *sqlx.DB
+	dir         string
+	table       string
+	lockName    string
+	lockTimeout time.Duration
+}
+
+// DbNewMigrator 创建迁移, dir 中为编号的 up/down sql文件
+func DbNewMigrator(db *sqlx.DB, dir string) *DbMigrator {
+	return &DbMigrator{
+		db:          db,
+		dir:         dir,
+		table:       DbMigrateTableDefault,
+		lockName:    "mcommon_migrate_" + DbMigrateTableDefault,
+		lockTimeout: 60 * time.Second,
+	}
+}
+
+// WithTable 设置迁移记录表
+func (m *DbMigrator) WithTable(table string) *DbMigrator {
+	m.table = table
+	m.lockName = "mcommon_migrate_" + table
+	return m
+}
+
+// WithLock 设置锁名和等待锁的超时时间
+func (m *DbMigrator) WithLock(name string, timeout time.Duration) *DbMigrator {
+	m.lockName = name
+	m.lockTimeout = timeout
+	return m
+}
+
+// Migrations 读取目录中的迁移文件, 按版本升序
+func (m *DbMigrator) Migrations() ([]*DbMigration, error) {
+	files, err := ioutil.ReadDir(m.dir)
+	if err != nil {
+		return nil, err
+	}
+	migrationMap := map[int64]*DbMigration{}
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		matches := dbMigrateFileReg.FindStringSubmatch(file.Name())
+		if matches == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		migration, ok := migrationMap[version]
+		if !ok {
+			migration = &DbMigration{
+				Version: version,
+				Name:    matches[2],
+			}
+			migrationMap[version] = migration
+		}
+		if migration.Name != matches[2] {
+			return nil, fmt.Errorf("migration %d name conflict: %s %s", version, migration.Name, matches[2])
+		}
+		p := filepath.Join(m.dir, file.Name())
+		if matches[3] == "up" {
+			migration.UpPath = p
+		} else {
+			migration.DownPath = p
+		}
+	}
+	var migrations []*DbMigration
+	for _, migration := range migrationMap {
+		if migration.UpPath == "" {
+			return nil, fmt.Errorf("migration %d %s no up file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Up 执行所有未执行的迁移, 返回执行的个数
+func (m *DbMigrator) Up(ctx context.Context) (int, error) {
+	count := 0
+	err := m.withLock(ctx, func() error {
+		migrations, records, err := m.load(ctx)
+		if err != nil {
+			return err
+		}
+		err = m.verify(migrations, records)
+		if err != nil {
+			return err
+		}
+		for _, migration := range migrations {
+			if _, ok := records[migration.Version]; ok {
+				continue
+			}
+			err = m.up(ctx, migration)
+			if err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Down 回滚最后执行的 n 个迁移, 返回回滚的个数
+func (m *DbMigrator) Down(ctx context.Context, n int) (int, error) {
+	count := 0
+	err := m.withLock(ctx, func() error {
+		migrations, records, err := m.load(ctx)
+		if err != nil {
+			return err
+		}
+		err = m.verify(migrations, records)
+		if err != nil {
+			return err
+		}
+		applied, err := m.applied(migrations, records, n)
+		if err != nil {
+			return err
+		}
+		for _, migration := range applied {
+			err = m.down(ctx, migration)
+			if err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Redo 回滚并重新执行最后一个迁移
+func (m *DbMigrator) Redo(ctx context.Context) error {
+	return m.withLock(ctx, func() error {
+		migrations, records, err := m.load(ctx)
+		if err != nil {
+			return err
+		}
+		err = m.verify(migrations, records)
+		if err != nil {
+			return err
+		}
+		applied, err := m.applied(migrations, records, 1)
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			return fmt.Errorf("no applied migration")
+		}
+		err = m.down(ctx, applied[0])
+		if err != nil {
+			return err
+		}
+		return m.up(ctx, applied[0])
+	})
+}
+
+// Status 获取所有迁移的状态, 按版本升序
+func (m *DbMigrator) Status(ctx context.Context) ([]*DbMigrationStatus, error) {
+	migrations, records, err := m.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statusMap := map[int64]*DbMigrationStatus{}
+	for _, migration := range migrations {
+		status := &DbMigrationStatus{
+			Version: migration.Version,
+			Name:    migration.Name,
+		}
+		record, ok := records[migration.Version]
+		if ok {
+			status.Applied = true
+			status.AppliedAt = record.AppliedAt
+			checksum, err := dbMigrateChecksum(migration.UpPath)
+			if err != nil {
+				return nil, err
+			}
+			status.Dirty = checksum != record.Checksum
+		}
+		statusMap[migration.Version] = status
+	}
+	for _, record := range records {
+		if _, ok := statusMap[record.Version]; ok {
+			continue
+		}
+		statusMap[record.Version] = &DbMigrationStatus{
+			Version:   record.Version,
+			Name:      record.Name,
+			Applied:   true,
+			AppliedAt: record.AppliedAt,
+			Missing:   true,
+		}
+	}
+	var statuses []*DbMigrationStatus
+	for _, status := range statusMap {
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+	return statuses, nil
+}
+
+// load 读取迁移文件和已执行的记录
+func (m *DbMigrator) load(ctx context.Context) ([]*DbMigration, map[int64]*dbMigrationRecord, error) {
+	migrations, err := m.Migrations()
+	if err != nil {
+		return nil, nil, err
+	}
+	_, err = dbExecRaw(
+		ctx,
+		m.db,
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+    version BIGINT NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    checksum CHAR(64) NOT NULL,
+    applied_at BIGINT NOT NULL,
+    PRIMARY KEY (version)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`, m.table),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	var rows []*dbMigrationRecord
+	err = DbSelectNamedContent(
+		ctx,
+		m.db,
+		&rows,
+		fmt.Sprintf(`SELECT
+    version,
+    name,
+    checksum,
+    applied_at
+FROM
+    %s`, m.table),
+		gin.H{},
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	records := map[int64]*dbMigrationRecord{}
+	for _, row := range rows {
+		records[row.Version] = row
+	}
+	return migrations, records, nil
+}
+
+// verify 检查已执行的迁移文件是否被修改
+func (m *DbMigrator) verify(migrations []*DbMigration, records map[int64]*dbMigrationRecord) error {
+	for _, migration := range migrations {
+		record, ok := records[migration.Version]
+		if !ok {
+			continue
+		}
+		checksum, err := dbMigrateChecksum(migration.UpPath)
+		if err != nil {
+			return err
+		}
+		if checksum != record.Checksum {
+			return fmt.Errorf("migration %d %s checksum mismatch", migration.Version, migration.Name)
+		}
+	}
+	return nil
+}
+
+// applied 获取最后执行的 n 个迁移, 按版本降序, 文件不存在时返回错误
+func (m *DbMigrator) applied(migrations []*DbMigration, records map[int64]*dbMigrationRecord, n int) ([]*DbMigration, error) {
+	migrationMap := map[int64]*DbMigration{}
+	for _, migration := range migrations {
+		migrationMap[migration.Version] = migration
+	}
+	var versions []int64
+	for version := range records {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i] > versions[j]
+	})
+	var applied []*DbMigration
+	for _, version := range versions {
+		if len(applied) >= n {
+			break
+		}
+		migration, ok := migrationMap[version]
+		if !ok {
+			return nil, fmt.Errorf("migration %d %s file missing", version, records[version].Name)
+		}
+		applied = append(applied, migration)
+	}
+	return applied, nil
+}
+
+// up 执行迁移并记录
+func (m *DbMigrator) up(ctx context.Context, migration *DbMigration) error {
+	checksum, err := dbMigrateChecksum(migration.UpPath)
+	if err != nil {
+		return err
+	}
+	Log.Infof("migrate up %d %s", migration.Version, migration.Name)
+	// mysql的DDL会隐式提交, 事物只能保证DML和记录一起提交
+	return DbTransaction(ctx, m.db, func(dbTx DbExeAble) error {
+		err := dbMigrateExecFile(ctx, dbTx, migration.UpPath)
+		if err != nil {
+			return err
+		}
+		_, err = DbExecuteCountNamedContent(
+			ctx,
+			dbTx,
+			fmt.Sprintf(`INSERT INTO %s (
+    version,
+    name,
+    checksum,
+    applied_at
+) VALUES (
+    :version,
+    :name,
+    :checksum,
+    :applied_at
+)`, m.table),
+			gin.H{
+				"version":    migration.Version,
+				"name":       migration.Name,
+				"checksum":   checksum,
+				"applied_at": time.Now().Unix(),
+			},
+		)
+		return err
+	})
+}
+
+// down 回滚迁移并删除记录
+func (m *DbMigrator) down(ctx context.Context, migration *DbMigration) error {
+	if migration.DownPath == "" {
+		return fmt.Errorf("migration %d %s no down file", migration.Version, migration.Name)
+	}
+	Log.Infof("migrate down %d %s", migration.Version, migration.Name)
+	return DbTransaction(ctx, m.db, func(dbTx DbExeAble) error {
+		err := dbMigrateExecFile(ctx, dbTx, migration.DownPath)
+		if err != nil {
+			return err
+		}
+		_, err = DbExecuteCountNamedContent(
+			ctx,
+			dbTx,
+			fmt.Sprintf(`DELETE FROM %s WHERE version=:version`, m.table),
+			gin.H{
+				"version": migration.Version,
+			},
+		)
+		return err
+	})
+}
+
+// withLock 获取mysql的命名锁后执行, 保证只有一个实例在迁移
+func (m *DbMigrator) withLock(ctx context.Context, f func() error) error {
+	// GET_LOCK 和会话绑定, 需要在同一个链接上加锁和解锁
+	conn, err := m.db.Connx(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	var locked sql.NullInt64
+	err = conn.GetContext(ctx, &locked, `SELECT GET_LOCK(?, ?)`, m.lockName, int64(m.lockTimeout/time.Second))
+	if err != nil {
+		return err
+	}
+	if !locked.Valid || locked.Int64 != 1 {
+		return fmt.Errorf("migrate get lock %s timeout", m.lockName)
+	}
+	defer func() {
+		_, err := conn.ExecContext(context.Background(), `SELECT RELEASE_LOCK(?)`, m.lockName)
+		if err != nil {
+			Log.Errorf("migrate release lock %s error: %s", m.lockName, err.Error())
+		}
+	}()
+	return f()
+}
+
+// dbMigrateChecksum 计算文件的sha256
+func dbMigrateChecksum(p string) (string, error) {
+	content, err := ioutil.ReadFile(p)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// dbMigrateExecFile 逐条执行文件中的sql
+func dbMigrateExecFile(ctx context.Context, tx DbExeAble, p string) error {
+	content, err := ioutil.ReadFile(p)
+	if err != nil {
+		return err
+	}
+	for _, stmt := range dbSplitStatements(string(content)) {
+		_, err = dbExecRaw(ctx, tx, stmt)
+		if err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(p), err)
+		}
+	}
+	return nil
+}
+
+// dbSplitStatements 按分号拆分sql语句, 忽略引号和注释中的分号, 去掉空语句
+func dbSplitStatements(content string) []string {
+	var stmts []string
+	var buf strings.Builder
+	var quote byte
+	flush := func() {
+		stmt := strings.TrimSpace(buf.String())
+		buf.Reset()
+		if stmt != "" {
+			stmts = append(stmts, stmt)
+		}
+	}
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		if quote != 0 {
+			buf.WriteByte(c)
+			if c == '\\' && quote != '`' && i+1 < len(content) {
+				i++
+				buf.WriteByte(content[i])
+				continue
+			}
+			if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+			buf.WriteByte(c)
+		case c == '#' || (c == '-' && strings.HasPrefix(content[i:], "-- ")):
+			// 单行注释
+			end := strings.IndexByte(content[i:], '\n')
+			if end < 0 {
+				i = len(content)
+			} else {
+				i += end
+				buf.WriteByte('\n')
+			}
+		case c == '/' && strings.HasPrefix(content[i:], "/*"):
+			// 多行注释, 保留mysql的 /*! */ 可执行注释
+			end := strings.Index(content[i+2:], "*/")
+			if end < 0 {
+				end = len(content) - i - 2
+			}
+			if strings.HasPrefix(content[i:], "/*!") {
+				buf.WriteString(content[i : i+2+end])
+				buf.WriteString("*/")
+			} else {
+				buf.WriteByte(' ')
+			}
+			i += end + 3
+		case c == ';':
+			flush()
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	flush()
+	return stmts
+}
@@ -0,0 +1,248 @@
+package mcommon
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDbSplitStatements(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{"simple", "CREATE TABLE a (id int);\nDROP TABLE b;", []string{"CREATE TABLE a (id int)", "DROP TABLE b"}},
+		{"empty", " ;\n; ", nil},
+		{"quote", "INSERT INTO a VALUES ('x;y', \"z;\");", []string{"INSERT INTO a VALUES ('x;y', \"z;\")"}},
+		{"escape", `INSERT INTO a VALUES ('it\'s;');`, []string{`INSERT INTO a VALUES ('it\'s;')`}},
+		{"line comment", "-- drop; it\nSELECT 1; # a;b\nSELECT 2", []string{"SELECT 1", "SELECT 2"}},
+		{"block comment", "SELECT /* a;b */ 1;", []string{"SELECT   1"}},
+		{"executable comment", "SELECT /*!40101 1 */;", []string{"SELECT /*!40101 1 */"}},
+		{"no tail", "SELECT 1", []string{"SELECT 1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := dbSplitStatements(tt.content)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("stmts = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// dbTestMigrateDir 创建迁移文件目录
+func dbTestMigrateDir(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "mcommon_migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestDbMigratorMigrations(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "sorted",
+			files: map[string]string{
+				"0010_b.up.sql":   "",
+				"0002_a.up.sql":   "",
+				"0002_a.down.sql": "",
+				"readme.md":       "",
+			},
+			want: []string{"2 a down", "10 b"},
+		},
+		{
+			name:    "name conflict",
+			files:   map[string]string{"0001_a.up.sql": "", "0001_b.down.sql": ""},
+			wantErr: true,
+		},
+		{
+			name:    "no up",
+			files:   map[string]string{"0001_a.down.sql": ""},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := dbTestMigrateDir(t, tt.files)
+			defer os.RemoveAll(dir)
+			migrations, err := DbNewMigrator(nil, dir).Migrations()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			var got []string
+			for _, m := range migrations {
+				s := fmt.Sprintf("%d %s", m.Version, m.Name)
+				if m.DownPath != "" {
+					s += " down"
+				}
+				got = append(got, s)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("migrations = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDbMigratorUpDown(t *testing.T) {
+	files := map[string]string{
+		"0001_a.up.sql":   "CREATE TABLE a (id int);",
+		"0001_a.down.sql": "DROP TABLE a;",
+		"0002_b.up.sql":   "CREATE TABLE b (id int);\nINSERT INTO b VALUES (1);",
+	}
+	checksum := func(t *testing.T, dir string, name string) string {
+		sum, err := dbMigrateChecksum(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sum
+	}
+	tests := []struct {
+		name      string
+		records   func(dir string) [][]interface{}
+		locked    int64
+		run       func(m *DbMigrator) (int, error)
+		wantCount int
+		wantErr   bool
+		wantExec  []string
+	}{
+		{
+			name:      "up all",
+			locked:    1,
+			run:       func(m *DbMigrator) (int, error) { return m.Up(context.Background()) },
+			wantCount: 2,
+			wantExec: []string{
+				"CREATE TABLE a (id int)",
+				"INSERT INTO schema_migrations",
+				"CREATE TABLE b (id int)",
+				"INSERT INTO b VALUES (1)",
+				"INSERT INTO schema_migrations",
+			},
+		},
+		{
+			name: "up rest",
+			records: func(dir string) [][]interface{} {
+				return [][]interface{}{{1, "a", checksum(t, dir, "0001_a.up.sql"), 100}}
+			},
+			locked:    1,
+			run:       func(m *DbMigrator) (int, error) { return m.Up(context.Background()) },
+			wantCount: 1,
+			wantExec: []string{
+				"CREATE TABLE b (id int)",
+				"INSERT INTO b VALUES (1)",
+				"INSERT INTO schema_migrations",
+			},
+		},
+		{
+			name: "checksum mismatch",
+			records: func(dir string) [][]interface{} {
+				return [][]interface{}{{1, "a", "changed", 100}}
+			},
+			locked:  1,
+			run:     func(m *DbMigrator) (int, error) { return m.Up(context.Background()) },
+			wantErr: true,
+		},
+		{
+			name:    "lock timeout",
+			locked:  0,
+			run:     func(m *DbMigrator) (int, error) { return m.Up(context.Background()) },
+			wantErr: true,
+		},
+		{
+			name: "down",
+			records: func(dir string) [][]interface{} {
+				return [][]interface{}{{1, "a", checksum(t, dir, "0001_a.up.sql"), 100}}
+			},
+			locked:    1,
+			run:       func(m *DbMigrator) (int, error) { return m.Down(context.Background(), 1) },
+			wantCount: 1,
+			wantExec: []string{
+				"DROP TABLE a",
+				"DELETE FROM schema_migrations",
+			},
+		},
+		{
+			name: "down no file",
+			records: func(dir string) [][]interface{} {
+				return [][]interface{}{
+					{1, "a", checksum(t, dir, "0001_a.up.sql"), 100},
+					{2, "b", checksum(t, dir, "0002_b.up.sql"), 100},
+				}
+			},
+			locked:  1,
+			run:     func(m *DbMigrator) (int, error) { return m.Down(context.Background(), 1) },
+			wantErr: true,
+		},
+		{
+			name: "down missing",
+			records: func(dir string) [][]interface{} {
+				return [][]interface{}{{3, "c", "x", 100}}
+			},
+			locked:  1,
+			run:     func(m *DbMigrator) (int, error) { return m.Down(context.Background(), 1) },
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := dbTestMigrateDir(t, files)
+			defer os.RemoveAll(dir)
+			var records [][]interface{}
+			if tt.records != nil {
+				records = tt.records(dir)
+			}
+			f := DbNewFake()
+			defer f.Close()
+			f.Expect(`GET_LOCK`).WillReturnRows([]string{"locked"}, []interface{}{tt.locked})
+			f.Expect(`RELEASE_LOCK`).WillReturnRows([]string{"released"}, []interface{}{1})
+			f.Expect(`^CREATE TABLE IF NOT EXISTS schema_migrations`).WillReturnResult(0, 0)
+			f.Expect(`(?s)^SELECT.*FROM\s+schema_migrations`).
+				WillReturnRows([]string{"version", "name", "checksum", "applied_at"}, records...)
+			f.Expect(`.`).WillReturnResult(0, 1)
+			count, err := tt.run(DbNewMigrator(f.DB, dir))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if count != tt.wantCount {
+				t.Fatalf("count = %d, want %d", count, tt.wantCount)
+			}
+			var got []string
+			for _, r := range f.Records() {
+				switch {
+				case strings.Contains(r.Query, "LOCK("),
+					strings.Contains(r.Query, "schema_migrations") && !strings.HasPrefix(r.Query, "INSERT") && !strings.HasPrefix(r.Query, "DELETE"),
+					r.Query == "BEGIN", r.Query == "COMMIT", r.Query == "ROLLBACK":
+					continue
+				}
+				if i := strings.Index(r.Query, " ("); i >= 0 && strings.HasPrefix(r.Query, "INSERT INTO schema_migrations") {
+					r.Query = r.Query[:i]
+				}
+				if strings.HasPrefix(r.Query, "DELETE FROM schema_migrations") {
+					r.Query = "DELETE FROM schema_migrations"
+				}
+				got = append(got, r.Query)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.wantExec) {
+				t.Fatalf("exec = %q, want %q", got, tt.wantExec)
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package mcommon
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DbStructNormalizeOptions 比较表结构前的规范化规则
+type DbStructNormalizeOptions struct {
+	// AutoIncrement 去掉表选项中的 AUTO_INCREMENT=
+	AutoIncrement bool
+	// Charset 去掉和表默认值相同的列字符集和排序规则
+	Charset bool
+	// IntWidth 去掉整数类型的显示宽度, 保留 tinyint(1)
+	IntWidth bool
+	// DefaultNull 去掉 DEFAULT NULL
+	DefaultNull bool
+	// KeyOrder 索引按主键, 唯一索引, 普通索引, 外键的顺序和名称排序
+	KeyOrder bool
+}
+
+// DbStructNormalizeDefault 启用所有规则
+func DbStructNormalizeDefault() *DbStructNormalizeOptions {
+	return &DbStructNormalizeOptions{
+		AutoIncrement: true,
+		Charset:       true,
+		IntWidth:      true,
+		DefaultNull:   true,
+		KeyOrder:      true,
+	}
+}
+
+var (
+	// dbNormalizeCreateReg 建表语句头
+	dbNormalizeCreateReg = regexp.MustCompile("(?is)^CREATE\\s+(TEMPORARY\\s+)?TABLE\\s+(IF\\s+NOT\\s+EXISTS\\s+)?(`[^`]+`|[\\w$]+)\\s*\\(")
+	// dbNormalizeAutoIncReg 表选项 AUTO_INCREMENT
+	dbNormalizeAutoIncReg = regexp.MustCompile(`(?i)\s*\bAUTO_INCREMENT\s*=\s*\d+`)
+	// dbNormalizeTableCharsetReg 表字符集
+	dbNormalizeTableCharsetReg = regexp.MustCompile(`(?i)\b(?:CHARSET|CHARACTER\s+SET)\s*=?\s*(\w+)`)
+	// dbNormalizeTableCollateReg 表排序规则
+	dbNormalizeTableCollateReg = regexp.MustCompile(`(?i)\bCOLLATE\s*=?\s*(\w+)`)
+	// dbNormalizeColumnCharsetReg 列字符集
+	dbNormalizeColumnCharsetReg = regexp.MustCompile(`(?i)\s+(?:CHARSET|CHARACTER\s+SET)\s+(\w+)`)
+	// dbNormalizeColumnCollateReg 列排序规则
+	dbNormalizeColumnCollateReg = regexp.MustCompile(`(?i)\s+COLLATE\s+(\w+)`)
+	// dbNormalizeIntWidthReg 整数显示宽度
+	dbNormalizeIntWidthReg = regexp.MustCompile(`(?i)\b(TINYINT|SMALLINT|MEDIUMINT|INT|INTEGER|BIGINT)\s*\(\s*(\d+)\s*\)`)
+	// dbNormalizeDefaultNullReg 默认值 NULL
+	dbNormalizeDefaultNullReg = regexp.MustCompile(`(?i)\s+DEFAULT\s+NULL\b`)
+	// dbNormalizeKeyReg 索引定义
+	dbNormalizeKeyReg = regexp.MustCompile("(?is)^(PRIMARY\\s+KEY|UNIQUE|KEY|INDEX|FULLTEXT|SPATIAL|CONSTRAINT|FOREIGN\\s+KEY|CHECK)\\b")
+	// dbNormalizeKeyNameReg 索引名
+	dbNormalizeKeyNameReg = regexp.MustCompile("(?is)^(?:UNIQUE\\s+|FULLTEXT\\s+|SPATIAL\\s+)?(?:KEY|INDEX|CONSTRAINT)\\s+(?:`([^`]+)`|([\\w$]+))")
+)
+
+// DbStructNormalize 规范化sql中的建表语句, 两边使用同样的规则后再比较可以去掉无意义的差异
+// 非建表语句原样保留, 注释会被去掉
+func DbStructNormalize(sqlStr string, opts *DbStructNormalizeOptions) string {
+	if opts == nil {
+		opts = DbStructNormalizeDefault()
+	}
+	var stmts []string
+	for _, stmt := range dbSplitStatements(sqlStr) {
+		stmts = append(stmts, dbNormalizeCreateTable(stmt, opts)+";")
+	}
+	return strings.Join(stmts, "\n")
+}
+
+// dbNormalizeCreateTable 规范化单个建表语句, 每个定义一行
+func dbNormalizeCreateTable(stmt string, opts *DbStructNormalizeOptions) string {
+	loc := dbNormalizeCreateReg.FindStringSubmatchIndex(stmt)
+	if loc == nil {
+		return stmt
+	}
+	head := stmt[:loc[1]]
+	defs, tail, ok := dbNormalizeSplitDefs(stmt[loc[1]:])
+	if !ok {
+		return stmt
+	}
+	tableOptions := strings.TrimSpace(tail)
+	if opts.AutoIncrement {
+		tableOptions = strings.TrimSpace(dbNormalizeAutoIncReg.ReplaceAllString(tableOptions, ""))
+	}
+	var tableCharset, tableCollate string
+	if m := dbNormalizeTableCharsetReg.FindStringSubmatch(tableOptions); m != nil {
+		tableCharset = m[1]
+	}
+	if m := dbNormalizeTableCollateReg.FindStringSubmatch(tableOptions); m != nil {
+		tableCollate = m[1]
+	}
+
+	var columns, keys []string
+	for _, def := range defs {
+		if dbNormalizeKeyReg.MatchString(def) {
+			keys = append(keys, def)
+			continue
+		}
+		if opts.Charset {
+			def = dbNormalizeRemoveEqual(dbNormalizeColumnCharsetReg, def, tableCharset)
+			def = dbNormalizeRemoveEqual(dbNormalizeColumnCollateReg, def, tableCollate)
+		}
+		if opts.IntWidth {
+			def = dbNormalizeIntWidthReg.ReplaceAllStringFunc(def, func(s string) string {
+				m := dbNormalizeIntWidthReg.FindStringSubmatch(s)
+				if strings.EqualFold(m[1], "TINYINT") && m[2] == "1" {
+					// tinyint(1) 一般作为布尔值使用
+					return s
+				}
+				return m[1]
+			})
+		}
+		if opts.DefaultNull {
+			def = dbNormalizeDefaultNullReg.ReplaceAllString(def, "")
+		}
+		columns = append(columns, def)
+	}
+	if opts.KeyOrder {
+		sort.SliceStable(keys, func(i, j int) bool {
+			ri, rj := dbNormalizeKeyRank(keys[i]), dbNormalizeKeyRank(keys[j])
+			if ri != rj {
+				return ri < rj
+			}
+			return dbNormalizeKeyName(keys[i]) < dbNormalizeKeyName(keys[j])
+		})
+	}
+
+	var b strings.Builder
+	b.WriteString(strings.TrimSpace(head))
+	b.WriteString("\n")
+	allDefs := append(columns, keys...)
+	for i, def := range allDefs {
+		b.WriteString("  ")
+		b.WriteString(def)
+		if i < len(allDefs)-1 {
+			b.WriteString(",")
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString(")")
+	if tableOptions != "" {
+		b.WriteString(" ")
+		b.WriteString(tableOptions)
+	}
+	return b.String()
+}
+
+// dbNormalizeSplitDefs 按顶层逗号拆分括号中的定义, 返回定义和括号后的表选项
+func dbNormalizeSplitDefs(s string) ([]string, string, bool) {
+	var defs []string
+	var quote byte
+	depth := 0
+	start := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if quote != 0 {
+			if c == '\\' && quote != '`' {
+				i++
+				continue
+			}
+			if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch c {
+		case '\'', '"', '`':
+			quote = c
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				defs = append(defs, dbNormalizeSpace(s[start:i]))
+				return defs, s[i+1:], true
+			}
+			depth--
+		case ',':
+			if depth == 0 {
+				defs = append(defs, dbNormalizeSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return nil, "", false
+}
+
+// dbNormalizeSpace 合并引号外的连续空白
+func dbNormalizeSpace(s string) string {
+	var b strings.Builder
+	var quote byte
+	isSpace := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if quote != 0 {
+			b.WriteByte(c)
+			if c == '\\' && quote != '`' && i+1 < len(s) {
+				i++
+				b.WriteByte(s[i])
+				continue
+			}
+			if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+			isSpace = true
+			continue
+		}
+		if isSpace && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		isSpace = false
+		if c == '\'' || c == '"' || c == '`' {
+			quote = c
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// dbNormalizeRemoveEqual 去掉值和表默认值相同的列属性
+func dbNormalizeRemoveEqual(reg *regexp.Regexp, def string, tableValue string) string {
+	if tableValue == "" {
+		return def
+	}
+	return reg.ReplaceAllStringFunc(def, func(s string) string {
+		m := reg.FindStringSubmatch(s)
+		if strings.EqualFold(m[1], tableValue) {
+			return ""
+		}
+		return s
+	})
+}
+
+// dbNormalizeKeyRank 索引排序
+func dbNormalizeKeyRank(def string) int {
+	upper := strings.ToUpper(def)
+	switch {
+	case strings.HasPrefix(upper, "PRIMARY"):
+		return 0
+	case strings.HasPrefix(upper, "UNIQUE"):
+		return 1
+	case strings.HasPrefix(upper, "KEY"), strings.HasPrefix(upper, "INDEX"):
+		return 2
+	case strings.HasPrefix(upper, "FULLTEXT"), strings.HasPrefix(upper, "SPATIAL"):
+		return 3
+	}
+	return 4
+}
+
+// dbNormalizeKeyName 索引名, 没有名字时使用定义
+func dbNormalizeKeyName(def string) string {
+	m := dbNormalizeKeyNameReg.FindStringSubmatch(def)
+	if m == nil {
+		return def
+	}
+	return m[1] + m[2]
+}
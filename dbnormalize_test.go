@@ -0,0 +1,111 @@
+package mcommon
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDbStructNormalize(t *testing.T) {
+	createSQL := "CREATE TABLE `t_a` (\n" +
+		"  `id` int(11) NOT NULL AUTO_INCREMENT,\n" +
+		"  `flag` tinyint(1) NOT NULL,\n" +
+		"  `name` varchar(32) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin DEFAULT NULL COMMENT 'a, (b)',\n" +
+		"  KEY `k_name` (`name`),\n" +
+		"  UNIQUE KEY `uk_flag` (`flag`),\n" +
+		"  PRIMARY KEY (`id`)\n" +
+		") ENGINE=InnoDB AUTO_INCREMENT=10 DEFAULT CHARSET=utf8mb4"
+	tests := []struct {
+		name string
+		opts *DbStructNormalizeOptions
+		want string
+	}{
+		{
+			name: "all",
+			want: "CREATE TABLE `t_a` (\n" +
+				"  `id` int NOT NULL AUTO_INCREMENT,\n" +
+				"  `flag` tinyint(1) NOT NULL,\n" +
+				"  `name` varchar(32) COLLATE utf8mb4_bin COMMENT 'a, (b)',\n" +
+				"  PRIMARY KEY (`id`),\n" +
+				"  UNIQUE KEY `uk_flag` (`flag`),\n" +
+				"  KEY `k_name` (`name`)\n" +
+				") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;",
+		},
+		{
+			name: "none",
+			opts: &DbStructNormalizeOptions{},
+			want: "CREATE TABLE `t_a` (\n" +
+				"  `id` int(11) NOT NULL AUTO_INCREMENT,\n" +
+				"  `flag` tinyint(1) NOT NULL,\n" +
+				"  `name` varchar(32) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin DEFAULT NULL COMMENT 'a, (b)',\n" +
+				"  KEY `k_name` (`name`),\n" +
+				"  UNIQUE KEY `uk_flag` (`flag`),\n" +
+				"  PRIMARY KEY (`id`)\n" +
+				") ENGINE=InnoDB AUTO_INCREMENT=10 DEFAULT CHARSET=utf8mb4;",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DbStructNormalize(createSQL, tt.opts)
+			if got != tt.want {
+				t.Fatalf("normalize =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+	if got := DbStructNormalize("DROP TABLE t_a; -- c", nil); got != "DROP TABLE t_a;" {
+		t.Fatalf("normalize other = %q", got)
+	}
+}
+
+func TestDbStructGetDiffContentNormalize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcommon_normalize")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "t_a.sql")
+	err = ioutil.WriteFile(p, []byte("CREATE TABLE `t_a` (\n"+
+		"  `id` int NOT NULL,\n"+
+		"  `name` varchar(32),\n"+
+		"  PRIMARY KEY (`id`)\n"+
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbTables := map[string]string{
+		"t_a": "CREATE TABLE `t_a` (\n" +
+			"  `id` int(11) NOT NULL,\n" +
+			"  `name` varchar(32) CHARACTER SET utf8mb4 DEFAULT NULL,\n" +
+			"  PRIMARY KEY (`id`)\n" +
+			") ENGINE=InnoDB AUTO_INCREMENT=3 DEFAULT CHARSET=utf8mb4",
+	}
+	tests := []struct {
+		name      string
+		normalize *DbStructNormalizeOptions
+		wantDiff  bool
+	}{
+		{"default", nil, true},
+		{"normalize", DbStructNormalizeDefault(), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := dbTestStructFake(dbTables)
+			defer f.Close()
+			result, err := DbStructGetDiffContent(
+				context.Background(),
+				f.DB,
+				&DbStructDiffOptions{Paths: []string{p}, Normalize: tt.normalize},
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			hasDiff := strings.Contains(result.SQL, "ALTER TABLE")
+			if hasDiff != tt.wantDiff {
+				t.Fatalf("diff = %v, want %v:\n%s", hasDiff, tt.wantDiff, result.SQL)
+			}
+		})
+	}
+}
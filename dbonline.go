@@ -0,0 +1,391 @@
+package mcommon
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// dbOnlineAlterReg 完整的 ALTER TABLE 语句
+var dbOnlineAlterReg = regexp.MustCompile("(?is)^ALTER\\s+TABLE\\s+`?([\\w$]+)`?\\s+(.*)$")
+
+// DbOnlineAlterOptions 在线修改表结构的选项
+type DbOnlineAlterOptions struct {
+	// ChunkSize 每次复制的行数, 默认1000
+	ChunkSize int
+	// ChunkSleep 每次复制后的等待时间
+	ChunkSleep time.Duration
+	// MaxThreadsRunning 数据库 Threads_running 超过时暂停复制, 0 为不检查
+	MaxThreadsRunning int64
+	// Progress 每次复制后的进度回调
+	Progress func(p *DbOnlineAlterProgress)
+	// BeforeCutOver 切换表之前调用, 返回错误时取消修改
+	BeforeCutOver func(ctx context.Context) error
+	// KeepOld 切换后保留旧表
+	KeepOld bool
+}
+
+// DbOnlineAlterProgress 复制进度
+type DbOnlineAlterProgress struct {
+	Table string
+	// Copied 已复制的行数
+	Copied int64
+	// Total 估算的总行数
+	Total int64
+	// LastKey 最后复制的主键
+	LastKey interface{}
+	Elapsed time.Duration
+}
+
+// DbOnlineAlter 使用影子表在线修改表结构, 不长时间锁表
+// 创建修改后的新表, 通过触发器同步修改, 按主键分块复制数据, 最后原子 RENAME 切换
+// alter 可以是完整的 ALTER TABLE 语句或者只有修改部分, 表需要有单列主键, 不支持重命名列
+// 执行DDL会隐式提交, tx 不能是事物
+func DbOnlineAlter(ctx context.Context, tx DbExeAble, table string, alter string, opts *DbOnlineAlterOptions) error {
+	if opts == nil {
+		opts = &DbOnlineAlterOptions{}
+	}
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = 1000
+	}
+	// 复制全表数据, 不按租户过滤
+	ctx = DbWithoutTenant(ctx)
+	if m := dbOnlineAlterReg.FindStringSubmatch(strings.TrimSpace(alter)); m != nil {
+		if m[1] != table {
+			return fmt.Errorf("online alter table %s but statement is for %s", table, m[1])
+		}
+		alter = m[2]
+	}
+	pk, err := dbOnlinePrimaryKey(ctx, tx, table)
+	if err != nil {
+		return err
+	}
+
+	newTable := "_" + table + "_new"
+	oldTable := "_" + table + "_old"
+	triggers := []string{"_" + table + "_ins", "_" + table + "_upd", "_" + table + "_del"}
+
+	cleanup := func() {
+		for _, trigger := range triggers {
+			_, err := dbExecRaw(context.Background(), tx, fmt.Sprintf("DROP TRIGGER IF EXISTS `%s`", trigger))
+			if err != nil {
+				Log.Errorf("online alter %s drop trigger %s error: %s", table, trigger, err.Error())
+			}
+		}
+		_, err := dbExecRaw(context.Background(), tx, fmt.Sprintf("DROP TABLE IF EXISTS `%s`", newTable))
+		if err != nil {
+			Log.Errorf("online alter %s drop table %s error: %s", table, newTable, err.Error())
+		}
+	}
+
+	Log.Infof("online alter %s create shadow table %s", table, newTable)
+	_, err = dbExecRaw(ctx, tx, fmt.Sprintf("CREATE TABLE `%s` LIKE `%s`", newTable, table))
+	if err != nil {
+		return err
+	}
+	err = dbOnlineAlterRun(ctx, tx, table, newTable, alter, pk, triggers, opts)
+	if err != nil {
+		cleanup()
+		return err
+	}
+
+	Log.Infof("online alter %s cut over", table)
+	_, err = dbExecRaw(ctx, tx, fmt.Sprintf("RENAME TABLE `%s` TO `%s`, `%s` TO `%s`", table, oldTable, newTable, table))
+	if err != nil {
+		cleanup()
+		return err
+	}
+	// 触发器跟随原表改名, 切换后删除
+	for _, trigger := range triggers {
+		_, err = dbExecRaw(ctx, tx, fmt.Sprintf("DROP TRIGGER IF EXISTS `%s`", trigger))
+		if err != nil {
+			return err
+		}
+	}
+	if !opts.KeepOld {
+		_, err = dbExecRaw(ctx, tx, fmt.Sprintf("DROP TABLE `%s`", oldTable))
+		if err != nil {
+			return err
+		}
+	}
+	Log.Infof("online alter %s done", table)
+	return nil
+}
+
+// dbOnlineAlterRun 修改影子表, 创建触发器并复制数据, 最后调用切换前回调
+func dbOnlineAlterRun(ctx context.Context, tx DbExeAble, table string, newTable string, alter string, pk string, triggers []string, opts *DbOnlineAlterOptions) error {
+	_, err := dbExecRaw(ctx, tx, fmt.Sprintf("ALTER TABLE `%s` %s", newTable, alter))
+	if err != nil {
+		return err
+	}
+	oldColumns, err := dbOnlineColumns(ctx, tx, table)
+	if err != nil {
+		return err
+	}
+	newColumns, err := dbOnlineColumns(ctx, tx, newTable)
+	if err != nil {
+		return err
+	}
+	// 只复制两个表都有的列
+	var columns []string
+	for _, column := range oldColumns {
+		if IsStringInSlice(newColumns, column) {
+			columns = append(columns, column)
+		}
+	}
+	if !IsStringInSlice(columns, pk) {
+		return fmt.Errorf("online alter %s primary key %s not in new table", table, pk)
+	}
+	columnsSQL := "`" + strings.Join(columns, "`, `") + "`"
+	newValuesSQL := "NEW.`" + strings.Join(columns, "`, NEW.`") + "`"
+
+	triggerSQLs := []string{
+		fmt.Sprintf(
+			"CREATE TRIGGER `%s` AFTER INSERT ON `%s` FOR EACH ROW REPLACE INTO `%s` (%s) VALUES (%s)",
+			triggers[0], table, newTable, columnsSQL, newValuesSQL,
+		),
+		fmt.Sprintf(
+			"CREATE TRIGGER `%s` AFTER UPDATE ON `%s` FOR EACH ROW BEGIN DELETE IGNORE FROM `%s` WHERE `%s` <=> OLD.`%s`; REPLACE INTO `%s` (%s) VALUES (%s); END",
+			triggers[1], table, newTable, pk, pk, newTable, columnsSQL, newValuesSQL,
+		),
+		fmt.Sprintf(
+			"CREATE TRIGGER `%s` AFTER DELETE ON `%s` FOR EACH ROW DELETE IGNORE FROM `%s` WHERE `%s` <=> OLD.`%s`",
+			triggers[2], table, newTable, pk, pk,
+		),
+	}
+	for _, triggerSQL := range triggerSQLs {
+		_, err = dbExecRaw(ctx, tx, triggerSQL)
+		if err != nil {
+			return err
+		}
+	}
+
+	progress := &DbOnlineAlterProgress{
+		Table: table,
+	}
+	progress.Total, err = dbOnlineEstimateRows(ctx, tx, table)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	for {
+		err = dbOnlineThrottle(ctx, tx, opts)
+		if err != nil {
+			return err
+		}
+		endKey, ok, err := dbOnlineChunkEnd(ctx, tx, table, pk, progress.LastKey, opts.ChunkSize)
+		if err != nil {
+			return err
+		}
+		var wheres []string
+		argMap := gin.H{}
+		if progress.LastKey != nil {
+			wheres = append(wheres, fmt.Sprintf("`%s` > :last_key", pk))
+			argMap["last_key"] = progress.LastKey
+		}
+		if ok {
+			wheres = append(wheres, fmt.Sprintf("`%s` <= :end_key", pk))
+			argMap["end_key"] = endKey
+		}
+		whereSQL := ""
+		if len(wheres) > 0 {
+			whereSQL = "WHERE " + strings.Join(wheres, " AND ")
+		}
+		// 触发器写入的数据更新, 复制时忽略已存在的行
+		count, err := DbExecuteCountNamedContent(
+			ctx,
+			tx,
+			fmt.Sprintf(
+				"INSERT IGNORE INTO `%s` (%s) SELECT %s FROM `%s` FORCE INDEX (`PRIMARY`) %s LOCK IN SHARE MODE",
+				newTable, columnsSQL, columnsSQL, table, whereSQL,
+			),
+			argMap,
+		)
+		if err != nil {
+			return err
+		}
+		progress.Copied += count
+		progress.Elapsed = time.Since(start)
+		if ok {
+			progress.LastKey = endKey
+		}
+		Log.Infof("online alter %s copied %d/%d rows", table, progress.Copied, progress.Total)
+		if opts.Progress != nil {
+			opts.Progress(progress)
+		}
+		if !ok {
+			break
+		}
+		if opts.ChunkSleep > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(opts.ChunkSleep):
+			}
+		}
+	}
+	if opts.BeforeCutOver != nil {
+		err = opts.BeforeCutOver(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dbOnlinePrimaryKey 获取表的单列主键
+func dbOnlinePrimaryKey(ctx context.Context, tx DbExeAble, table string) (string, error) {
+	var rows []struct {
+		ColumnName string `db:"column_name"`
+	}
+	err := DbSelectNamedContent(
+		ctx,
+		tx,
+		&rows,
+		`SELECT
+    column_name AS column_name
+FROM
+    information_schema.key_column_usage
+WHERE
+    table_schema=DATABASE()
+    AND table_name=:table_name
+    AND constraint_name='PRIMARY'
+ORDER BY
+    ordinal_position`,
+		gin.H{
+			"table_name": table,
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+	if len(rows) != 1 {
+		return "", fmt.Errorf("online alter %s need single column primary key, got %d", table, len(rows))
+	}
+	return rows[0].ColumnName, nil
+}
+
+// dbOnlineColumns 获取表的列名
+func dbOnlineColumns(ctx context.Context, tx DbExeAble, table string) ([]string, error) {
+	var rows []struct {
+		ColumnName string `db:"column_name"`
+	}
+	err := DbSelectNamedContent(
+		ctx,
+		tx,
+		&rows,
+		`SELECT
+    column_name AS column_name
+FROM
+    information_schema.columns
+WHERE
+    table_schema=DATABASE()
+    AND table_name=:table_name
+ORDER BY
+    ordinal_position`,
+		gin.H{
+			"table_name": table,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	columns := make([]string, len(rows))
+	for i, row := range rows {
+		columns[i] = row.ColumnName
+	}
+	return columns, nil
+}
+
+// dbOnlineEstimateRows 估算表的行数
+func dbOnlineEstimateRows(ctx context.Context, tx DbExeAble, table string) (int64, error) {
+	var row struct {
+		TableRows *int64 `db:"table_rows"`
+	}
+	_, err := DbGetNamedContent(
+		ctx,
+		tx,
+		&row,
+		`SELECT
+    table_rows AS table_rows
+FROM
+    information_schema.tables
+WHERE
+    table_schema=DATABASE()
+    AND table_name=:table_name`,
+		gin.H{
+			"table_name": table,
+		},
+	)
+	if err != nil {
+		return 0, err
+	}
+	if row.TableRows == nil {
+		return 0, nil
+	}
+	return *row.TableRows, nil
+}
+
+// dbOnlineChunkEnd 获取下一块的最后一个主键, 剩余不足一块时返回false
+func dbOnlineChunkEnd(ctx context.Context, tx DbExeAble, table string, pk string, lastKey interface{}, chunkSize int) (interface{}, bool, error) {
+	whereSQL := ""
+	argMap := gin.H{}
+	if lastKey != nil {
+		whereSQL = fmt.Sprintf("WHERE `%s` > :last_key", pk)
+		argMap["last_key"] = lastKey
+	}
+	rows, err := DbNamedRowsContent(
+		ctx,
+		tx,
+		fmt.Sprintf(
+			"SELECT `%s` AS k FROM `%s` FORCE INDEX (`PRIMARY`) %s ORDER BY `%s` LIMIT 1 OFFSET %d",
+			pk, table, whereSQL, pk, chunkSize-1,
+		),
+		argMap,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(rows) == 0 {
+		return nil, false, nil
+	}
+	return rows[0]["k"], true, nil
+}
+
+// dbOnlineThrottle Threads_running 超过限制时等待
+func dbOnlineThrottle(ctx context.Context, tx DbExeAble, opts *DbOnlineAlterOptions) error {
+	if opts.MaxThreadsRunning <= 0 {
+		return nil
+	}
+	for {
+		var row struct {
+			Name  string `db:"Variable_name"`
+			Value string `db:"Value"`
+		}
+		_, err := DbGetNamedContent(
+			ctx,
+			tx,
+			&row,
+			`SHOW GLOBAL STATUS LIKE 'Threads_running'`,
+			gin.H{},
+		)
+		if err != nil {
+			return err
+		}
+		running, _ := strconv.ParseInt(row.Value, 10, 64)
+		if running <= opts.MaxThreadsRunning {
+			return nil
+		}
+		Log.Warnf("online alter throttle threads running %d > %d", running, opts.MaxThreadsRunning)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package mcommon
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDbOnlineAlter(t *testing.T) {
+	errCutOver := errors.New("cut over")
+	tests := []struct {
+		name         string
+		alter        string
+		pkRows       [][]interface{}
+		opts         *DbOnlineAlterOptions
+		wantErr      bool
+		wantExec     []string
+		wantProgress []int64
+	}{
+		{
+			name:   "ok",
+			alter:  "ALTER TABLE `t_a` ADD COLUMN `age` int NOT NULL",
+			pkRows: [][]interface{}{{"id"}},
+			wantExec: []string{
+				"CREATE TABLE `_t_a_new` LIKE `t_a`",
+				"ALTER TABLE `_t_a_new` ADD COLUMN `age` int NOT NULL",
+				"CREATE TRIGGER `_t_a_ins`",
+				"CREATE TRIGGER `_t_a_upd`",
+				"CREATE TRIGGER `_t_a_del`",
+				"INSERT IGNORE INTO `_t_a_new` (`id`, `name`) SELECT `id`, `name` FROM `t_a` FORCE INDEX (`PRIMARY`) WHERE `id` <= ?",
+				"INSERT IGNORE INTO `_t_a_new` (`id`, `name`) SELECT `id`, `name` FROM `t_a` FORCE INDEX (`PRIMARY`) WHERE `id` > ?",
+				"RENAME TABLE `t_a` TO `_t_a_old`, `_t_a_new` TO `t_a`",
+				"DROP TRIGGER IF EXISTS `_t_a_ins`",
+				"DROP TRIGGER IF EXISTS `_t_a_upd`",
+				"DROP TRIGGER IF EXISTS `_t_a_del`",
+				"DROP TABLE `_t_a_old`",
+			},
+			wantProgress: []int64{2, 3},
+		},
+		{
+			name:    "other table",
+			alter:   "ALTER TABLE `t_b` ADD COLUMN `age` int NOT NULL",
+			pkRows:  [][]interface{}{{"id"}},
+			wantErr: true,
+		},
+		{
+			name:    "no primary key",
+			alter:   "ADD COLUMN `age` int NOT NULL",
+			wantErr: true,
+		},
+		{
+			name:   "cut over error",
+			alter:  "ADD COLUMN `age` int NOT NULL",
+			pkRows: [][]interface{}{{"id"}},
+			opts: &DbOnlineAlterOptions{
+				BeforeCutOver: func(ctx context.Context) error {
+					return errCutOver
+				},
+			},
+			wantErr: true,
+			wantExec: []string{
+				"CREATE TABLE `_t_a_new` LIKE `t_a`",
+				"ALTER TABLE `_t_a_new` ADD COLUMN `age` int NOT NULL",
+				"CREATE TRIGGER `_t_a_ins`",
+				"CREATE TRIGGER `_t_a_upd`",
+				"CREATE TRIGGER `_t_a_del`",
+				"INSERT IGNORE INTO `_t_a_new` (`id`, `name`) SELECT `id`, `name` FROM `t_a` FORCE INDEX (`PRIMARY`) WHERE `id` <= ?",
+				"INSERT IGNORE INTO `_t_a_new` (`id`, `name`) SELECT `id`, `name` FROM `t_a` FORCE INDEX (`PRIMARY`) WHERE `id` > ?",
+				"DROP TRIGGER IF EXISTS `_t_a_ins`",
+				"DROP TRIGGER IF EXISTS `_t_a_upd`",
+				"DROP TRIGGER IF EXISTS `_t_a_del`",
+				"DROP TABLE IF EXISTS `_t_a_new`",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := DbNewFake()
+			defer f.Close()
+			f.Expect(`information_schema\.key_column_usage`).WithArgs("t_a").
+				WillReturnRows([]string{"column_name"}, tt.pkRows...)
+			f.Expect(`information_schema\.columns`).WithArgs("t_a").
+				WillReturnRows([]string{"column_name"}, []interface{}{"id"}, []interface{}{"name"})
+			f.Expect(`information_schema\.columns`).WithArgs("_t_a_new").
+				WillReturnRows([]string{"column_name"}, []interface{}{"id"}, []interface{}{"name"}, []interface{}{"age"})
+			f.Expect(`information_schema\.tables`).WillReturnRows([]string{"table_rows"}, []interface{}{3})
+			f.Expect("^SELECT `id` AS k").Once().WillReturnRows([]string{"k"}, []interface{}{2})
+			f.Expect("^SELECT `id` AS k").WillReturnRows([]string{"k"})
+			f.Expect("^INSERT IGNORE.*`id` <= \\?").WithArgs(int64(2)).WillReturnResult(0, 2)
+			f.Expect("^INSERT IGNORE.*`id` > \\?").WithArgs(int64(2)).WillReturnResult(0, 1)
+			f.Expect(`.`).WillReturnResult(0, 0)
+			opts := tt.opts
+			if opts == nil {
+				opts = &DbOnlineAlterOptions{}
+			}
+			opts.ChunkSize = 2
+			var progress []int64
+			opts.Progress = func(p *DbOnlineAlterProgress) {
+				if p.Total != 3 {
+					t.Errorf("total = %d", p.Total)
+				}
+				progress = append(progress, p.Copied)
+			}
+			err := DbOnlineAlter(context.Background(), f.DB, "t_a", tt.alter, opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			var got []string
+			for _, r := range f.Records() {
+				if strings.HasPrefix(r.Query, "SELECT") {
+					continue
+				}
+				query := r.Query
+				if strings.HasPrefix(query, "CREATE TRIGGER") {
+					query = strings.Join(strings.Fields(query)[:3], " ")
+				}
+				query = strings.TrimSuffix(query, " LOCK IN SHARE MODE")
+				got = append(got, query)
+			}
+			if !reflect.DeepEqual(got, tt.wantExec) {
+				t.Fatalf("exec =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.wantExec, "\n"))
+			}
+			if tt.wantProgress != nil && !reflect.DeepEqual(progress, tt.wantProgress) {
+				t.Fatalf("progress = %v, want %v", progress, tt.wantProgress)
+			}
+		})
+	}
+}
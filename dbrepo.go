@@ -0,0 +1,310 @@
+package mcommon
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DbRepo 单表通用操作, 根据结构体的db标签生成sql
+type DbRepo struct {
+	table        string
+	pk           string
+	versionCol   string
+	softCol      string
+	rt           reflect.Type
+	columns      []string
+	fieldIndexes map[string][]int
+}
+
+// DbNewRepo 创建单表操作对象, model 为结构体或结构体指针
+func DbNewRepo(table string, pk string, model interface{}) (*DbRepo, error) {
+	rt := reflect.TypeOf(model)
+	for rt != nil && rt.Kind() == reflect.Ptr {
+		rt = rt.Elem()
+	}
+	if rt == nil || rt.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("repo model not struct: %T", model)
+	}
+	r := DbRepo{
+		table:        table,
+		pk:           pk,
+		rt:           rt,
+		fieldIndexes: map[string][]int{},
+	}
+	r.parseFields(rt, nil)
+	if _, ok := r.fieldIndexes[pk]; !ok {
+		return nil, fmt.Errorf("repo model %s no pk column: %s", rt.Name(), pk)
+	}
+	return &r, nil
+}
+
+// parseFields 解析db标签, 匿名嵌入的结构体展开处理
+func (r *DbRepo) parseFields(rt reflect.Type, index []int) {
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		fieldIndex := append(append([]int{}, index...), i)
+		tag := f.Tag.Get("db")
+		if tag == "" {
+			if f.Anonymous && f.Type.Kind() == reflect.Struct {
+				r.parseFields(f.Type, fieldIndex)
+			}
+			continue
+		}
+		column := strings.Split(tag, ",")[0]
+		if column == "-" || column == "" {
+			continue
+		}
+		if _, ok := r.fieldIndexes[column]; ok {
+			continue
+		}
+		r.columns = append(r.columns, column)
+		r.fieldIndexes[column] = fieldIndex
+	}
+}
+
+// WithSoftDelete 使用软删除, column 为删除时间列, 只对当前对象生效
+// 查询和更新时过滤已删除数据, DeleteByID 改为设置删除时间, 不使用 QuerySetSoftDelete 的全局设置
+func (r *DbRepo) WithSoftDelete(column string) *DbRepo {
+	r.softCol = column
+	return r
+}
+
+// WithVersion 使用乐观锁, column 为版本号列, 需要是结构体中的列
+func (r *DbRepo) WithVersion(column string) (*DbRepo, error) {
+	if _, ok := r.fieldIndexes[column]; !ok {
+		return nil, fmt.Errorf("repo model %s no version column: %s", r.rt.Name(), column)
+	}
+	r.versionCol = column
+	return r, nil
+}
+
+// Table 表名
+func (r *DbRepo) Table() string {
+	return r.table
+}
+
+// Columns 列名
+func (r *DbRepo) Columns() []string {
+	return r.columns
+}
+
+// selectColumns 查询列
+func (r *DbRepo) selectColumns() []QueryMaker {
+	columns := make([]QueryMaker, len(r.columns))
+	for i, column := range r.columns {
+		columns[i] = QueryColumn(column)
+	}
+	return columns
+}
+
+// selectQuery 查询语句, 使用软删除时过滤已删除数据
+func (r *DbRepo) selectQuery(cond QueryMaker) *selectData {
+	q := QuerySelect(r.selectColumns()...).
+		From(r.table).
+		Where(cond)
+	if r.softCol != "" {
+		q.WithTrashed().Where(QueryIsNull(r.softCol))
+	}
+	return q
+}
+
+// updateQuery 更新语句, 使用软删除时不更新已删除数据
+func (r *DbRepo) updateQuery(updates []QueryMaker, conds ...QueryMaker) *updateData {
+	q := QueryUpdate(r.table).
+		Update(updates...).
+		Where(conds...)
+	if r.softCol != "" {
+		q.WithTrashed().Where(QueryIsNull(r.softCol))
+	}
+	return q
+}
+
+// structValue 获取结构体的值
+func (r *DbRepo) structValue(obj interface{}) (reflect.Value, error) {
+	rv := reflect.Indirect(reflect.ValueOf(obj))
+	if rv.Type() != r.rt {
+		return reflect.Value{}, fmt.Errorf("repo %s obj type error: %T", r.table, obj)
+	}
+	return rv, nil
+}
+
+// GetByID 根据主键获取
+func (r *DbRepo) GetByID(ctx context.Context, tx DbExeAble, id interface{}, dest interface{}) (bool, error) {
+	query, argMap, err := r.selectQuery(QueryEq{K: r.pk, V: id}).ToSQL()
+	if err != nil {
+		return false, err
+	}
+	return DbGetNamedContent(ctx, tx, dest, string(query), argMap)
+}
+
+// ListByIDs 根据主键列表获取, ids 为主键切片, dest 为结构体切片的指针
+func (r *DbRepo) ListByIDs(ctx context.Context, tx DbExeAble, ids interface{}, dest interface{}) error {
+	idsValue := reflect.ValueOf(ids)
+	if idsValue.Kind() != reflect.Slice && idsValue.Kind() != reflect.Array {
+		return fmt.Errorf("repo %s ids not slice: %T", r.table, ids)
+	}
+	if idsValue.Len() == 0 {
+		return nil
+	}
+	query, argMap, err := r.selectQuery(QueryEq{K: r.pk, V: ids}).ToSQL()
+	if err != nil {
+		return err
+	}
+	return DbSelectNamedContent(ctx, tx, dest, string(query), argMap)
+}
+
+// insertColumns 插入的列和值, 主键为零值时由数据库生成
+func (r *DbRepo) insertColumns(obj interface{}) ([]string, []interface{}, error) {
+	rv, err := r.structValue(obj)
+	if err != nil {
+		return nil, nil, err
+	}
+	var columns []string
+	var values []interface{}
+	for _, column := range r.columns {
+		fv := rv.FieldByIndex(r.fieldIndexes[column])
+		if column == r.pk && fv.IsZero() {
+			continue
+		}
+		columns = append(columns, column)
+		values = append(values, fv.Interface())
+	}
+	return columns, values, nil
+}
+
+// Insert 插入, 返回lastID
+func (r *DbRepo) Insert(ctx context.Context, tx DbExeAble, obj interface{}) (int64, error) {
+	columns, values, err := r.insertColumns(obj)
+	if err != nil {
+		return 0, err
+	}
+	query, argMap, err := QueryInsert(r.table).
+		Columns(columns...).
+		Values(values...).
+		ToSQL()
+	if err != nil {
+		return 0, err
+	}
+	return DbExecuteLastIDNamedContent(ctx, tx, string(query), argMap)
+}
+
+// Upsert 插入, 唯一键冲突时更新主键外的其他列, 返回影响行数
+func (r *DbRepo) Upsert(ctx context.Context, tx DbExeAble, obj interface{}) (int64, error) {
+	columns, values, err := r.insertColumns(obj)
+	if err != nil {
+		return 0, err
+	}
+	var duplicates []QueryMaker
+	for _, column := range columns {
+		if column == r.pk {
+			continue
+		}
+		duplicates = append(duplicates, QueryDuplicateValue(column))
+	}
+	query, argMap, err := QueryInsert(r.table).
+		Columns(columns...).
+		Values(values...).
+		Duplicates(duplicates...).
+		ToSQL()
+	if err != nil {
+		return 0, err
+	}
+	return DbExecuteCountNamedContent(ctx, tx, string(query), argMap)
+}
+
+// UpdateByID 根据主键更新指定列, 不能更新主键和版本号, 使用乐观锁时版本号加1但不检查版本
+func (r *DbRepo) UpdateByID(ctx context.Context, tx DbExeAble, id interface{}, updateMap H) (int64, error) {
+	keys := make([]string, 0, len(updateMap))
+	for k := range updateMap {
+		if k == r.pk || (r.versionCol != "" && k == r.versionCol) {
+			return 0, fmt.Errorf("repo %s can not update column: %s", r.table, k)
+		}
+		keys = append(keys, k)
+	}
+	// 按列名排序, 保证相同的列生成相同的sql
+	sort.Strings(keys)
+	var updates []QueryMaker
+	for _, k := range keys {
+		updates = append(updates, QueryEq{K: k, V: updateMap[k]})
+	}
+	if r.versionCol != "" {
+		updates = append(updates, QueryEqRaw{K: r.versionCol, V: r.versionCol + "+1"})
+	}
+	query, argMap, err := r.updateQuery(updates, QueryEq{K: r.pk, V: id}).ToSQL()
+	if err != nil {
+		return 0, err
+	}
+	return DbExecuteCountNamedContent(ctx, tx, string(query), argMap)
+}
+
+// Update 根据主键更新结构体的所有列
+// 使用乐观锁时检查版本号, 版本不一致返回 ErrStaleObject, 成功后结构体的版本号加1
+func (r *DbRepo) Update(ctx context.Context, tx DbExeAble, obj interface{}) error {
+	rv, err := r.structValue(obj)
+	if err != nil {
+		return err
+	}
+	var updates []QueryMaker
+	for _, column := range r.columns {
+		if column == r.pk || column == r.versionCol || column == r.softCol {
+			continue
+		}
+		updates = append(updates, QueryEq{K: column, V: rv.FieldByIndex(r.fieldIndexes[column]).Interface()})
+	}
+	conds := []QueryMaker{
+		QueryEq{K: r.pk, V: rv.FieldByIndex(r.fieldIndexes[r.pk]).Interface()},
+	}
+	var versionValue reflect.Value
+	if r.versionCol != "" {
+		versionValue = rv.FieldByIndex(r.fieldIndexes[r.versionCol])
+		updates = append(updates, QueryEqRaw{K: r.versionCol, V: r.versionCol + "+1"})
+		conds = append(conds, QueryEq{K: r.versionCol, V: versionValue.Interface()})
+	}
+	query, argMap, err := r.updateQuery(updates, conds...).ToSQL()
+	if err != nil {
+		return err
+	}
+	count, err := DbExecuteCountNamedContent(ctx, tx, string(query), argMap)
+	if err != nil {
+		return err
+	}
+	if r.versionCol == "" {
+		return nil
+	}
+	if count == 0 {
+		return ErrStaleObject
+	}
+	if versionValue.CanSet() {
+		switch versionValue.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			versionValue.SetInt(versionValue.Int() + 1)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			versionValue.SetUint(versionValue.Uint() + 1)
+		}
+	}
+	return nil
+}
+
+// DeleteByID 根据主键删除, 使用软删除时设置删除时间
+func (r *DbRepo) DeleteByID(ctx context.Context, tx DbExeAble, id interface{}) (int64, error) {
+	if r.softCol == "" {
+		return DbDeleteKV(ctx, tx, r.table, []string{r.pk}, []interface{}{id})
+	}
+	query, argMap, err := r.updateQuery(
+		[]QueryMaker{QueryEq{K: r.softCol, V: time.Now()}},
+		QueryEq{K: r.pk, V: id},
+	).ToSQL()
+	if err != nil {
+		return 0, err
+	}
+	return DbExecuteCountNamedContent(ctx, tx, string(query), argMap)
+}
+
+// HardDeleteByID 根据主键物理删除
+func (r *DbRepo) HardDeleteByID(ctx context.Context, tx DbExeAble, id interface{}) (int64, error) {
+	return DbHardDeleteKV(ctx, tx, r.table, []string{r.pk}, []interface{}{id})
+}
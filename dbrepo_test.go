@@ -0,0 +1,321 @@
+package mcommon
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// dbTestUser 测试模型
+type dbTestUser struct {
+	ID      int64  `db:"id"`
+	Name    string `db:"name"`
+	Age     int64  `db:"age"`
+	Version int64  `db:"version"`
+	Ignore  string `db:"-"`
+}
+
+func TestDbNewRepo(t *testing.T) {
+	tests := []struct {
+		name    string
+		model   interface{}
+		pk      string
+		wantErr bool
+		want    []string
+	}{
+		{"struct", dbTestUser{}, "id", false, []string{"id", "name", "age", "version"}},
+		{"pointer", &dbTestUser{}, "id", false, []string{"id", "name", "age", "version"}},
+		{"not struct", 1, "id", true, nil},
+		{"nil", nil, "id", true, nil},
+		{"no pk", dbTestUser{}, "uid", true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := DbNewRepo("t_user", tt.pk, tt.model)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(r.Columns(), tt.want) {
+				t.Fatalf("columns = %v, want %v", r.Columns(), tt.want)
+			}
+		})
+	}
+}
+
+func TestDbRepoUpdateByID(t *testing.T) {
+	tests := []struct {
+		name      string
+		version   bool
+		updateMap H
+		wantErr   bool
+		wantSQL   []string
+	}{
+		{
+			name:      "sorted",
+			updateMap: H{"name": "a", "age": 1},
+			wantSQL:   []string{"age=?", "name=?"},
+		},
+		{
+			name:      "version",
+			version:   true,
+			updateMap: H{"name": "a"},
+			wantSQL:   []string{"name=?", "version=version+1"},
+		},
+		{
+			name:      "pk",
+			updateMap: H{"id": 2},
+			wantErr:   true,
+		},
+		{
+			name:      "version column",
+			version:   true,
+			updateMap: H{"version": 2},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := DbNewFake()
+			defer f.Close()
+			f.Expect(`^UPDATE`).WillReturnResult(0, 1)
+			r, err := DbNewRepo("t_user", "id", dbTestUser{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.version {
+				_, err = r.WithVersion("version")
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			var queries []string
+			for i := 0; i < 5; i++ {
+				_, err = r.UpdateByID(context.Background(), f.DB, 1, tt.updateMap)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+				}
+				if err != nil {
+					if len(f.Records()) != 0 {
+						t.Fatalf("sql executed: %v", f.Records())
+					}
+					return
+				}
+			}
+			for _, record := range f.Records() {
+				queries = append(queries, record.Query)
+			}
+			for _, query := range queries[1:] {
+				if query != queries[0] {
+					t.Fatalf("query changed: %s != %s", query, queries[0])
+				}
+			}
+			last := -1
+			for _, part := range tt.wantSQL {
+				i := strings.Index(queries[0], part)
+				if i <= last {
+					t.Fatalf("query %s want %v in order", queries[0], tt.wantSQL)
+				}
+				last = i
+			}
+		})
+	}
+}
+
+func TestDbRepoUpdateVersion(t *testing.T) {
+	tests := []struct {
+		name        string
+		affected    int64
+		wantErr     error
+		wantVersion int64
+	}{
+		{"ok", 1, nil, 4},
+		{"stale", 0, ErrStaleObject, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := DbNewFake()
+			defer f.Close()
+			f.Expect(`^UPDATE`).WithArgs("a", int64(2), int64(1), int64(3)).WillReturnResult(0, tt.affected)
+			r, err := DbNewRepo("t_user", "id", dbTestUser{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = r.WithVersion("version")
+			if err != nil {
+				t.Fatal(err)
+			}
+			u := &dbTestUser{ID: 1, Name: "a", Age: 2, Version: 3}
+			err = r.Update(context.Background(), f.DB, u)
+			if err != tt.wantErr {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if u.Version != tt.wantVersion {
+				t.Fatalf("version = %d, want %d", u.Version, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestDbRepoGetInsert(t *testing.T) {
+	f := DbNewFake()
+	defer f.Close()
+	f.Expect(`^SELECT`).WithArgs(1).WillReturnRows(
+		[]string{"id", "name", "age", "version"},
+		[]interface{}{1, "a", 2, 3},
+	)
+	f.Expect(`^INSERT`).WithArgs("b", 0, 0).WillReturnResult(5, 1)
+	r, err := DbNewRepo("t_user", "id", dbTestUser{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var u dbTestUser
+	ok, err := r.GetByID(context.Background(), f.DB, 1, &u)
+	if err != nil || !ok {
+		t.Fatalf("ok = %v, err = %v", ok, err)
+	}
+	if u != (dbTestUser{ID: 1, Name: "a", Age: 2, Version: 3}) {
+		t.Fatalf("user = %#v", u)
+	}
+	id, err := r.Insert(context.Background(), f.DB, &dbTestUser{Name: "b"})
+	if err != nil || id != 5 {
+		t.Fatalf("id = %d, err = %v", id, err)
+	}
+	_, err = r.Insert(context.Background(), f.DB, &struct{}{})
+	if err == nil {
+		t.Fatalf("want obj type error")
+	}
+}
+
+func TestDbRepoSoftDelete(t *testing.T) {
+	tests := []struct {
+		name    string
+		run     func(r *DbRepo, tx DbExeAble) error
+		pattern string
+	}{
+		{
+			name: "get",
+			run: func(r *DbRepo, tx DbExeAble) error {
+				_, err := r.GetByID(context.Background(), tx, 1, &dbTestUser{})
+				return err
+			},
+			pattern: `(?s)^SELECT.*WHERE\s+id=\?\s+AND deleted_at IS NULL`,
+		},
+		{
+			name: "update",
+			run: func(r *DbRepo, tx DbExeAble) error {
+				_, err := r.UpdateByID(context.Background(), tx, 1, H{"name": "a"})
+				return err
+			},
+			pattern: `(?s)^UPDATE.*WHERE\s+id=\?\s+AND deleted_at IS NULL`,
+		},
+		{
+			name: "delete",
+			run: func(r *DbRepo, tx DbExeAble) error {
+				_, err := r.DeleteByID(context.Background(), tx, 1)
+				return err
+			},
+			pattern: `(?s)^UPDATE.*deleted_at=\?.*WHERE\s+id=\?\s+AND deleted_at IS NULL`,
+		},
+		{
+			name: "hard delete",
+			run: func(r *DbRepo, tx DbExeAble) error {
+				_, err := r.HardDeleteByID(context.Background(), tx, 1)
+				return err
+			},
+			pattern: `(?s)^DELETE.*WHERE\s+id=\?\s*$`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := DbNewFake()
+			defer f.Close()
+			f.Expect(tt.pattern).WillReturnResult(0, 1).WillReturnRows([]string{"id"})
+			r, err := DbNewRepo("t_user", "id", dbTestUser{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.WithSoftDelete("deleted_at")
+			err = tt.run(r, f.DB)
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestDbRepoSoftDeleteNotGlobal(t *testing.T) {
+	r, err := DbNewRepo("t_user", "id", dbTestUser{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.WithSoftDelete("deleted_at")
+	query, _, err := QuerySelect(QueryColumn("id")).From("t_user").ToSQL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(query), "deleted_at") {
+		t.Fatalf("repo soft delete changed global query: %s", query)
+	}
+}
+
+func TestDbRepoWithVersion(t *testing.T) {
+	tests := []struct {
+		column  string
+		wantErr bool
+	}{
+		{"version", false},
+		{"ver", true},
+		{"", true},
+	}
+	for _, tt := range tests {
+		r, err := DbNewRepo("t_user", "id", dbTestUser{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = r.WithVersion(tt.column)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("column %q err = %v, wantErr %v", tt.column, err, tt.wantErr)
+		}
+	}
+}
+
+func TestDbRepoListByIDs(t *testing.T) {
+	tests := []struct {
+		name    string
+		ids     interface{}
+		wantErr bool
+		wantSQL bool
+	}{
+		{"slice", []int64{1, 2}, false, true},
+		{"empty", []int64{}, false, false},
+		{"not slice", int64(1), true, false},
+		{"nil", nil, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := DbNewFake()
+			defer f.Close()
+			f.Expect(`(?s)^SELECT.*IN`).WithArgs(int64(1), int64(2)).WillReturnRows(
+				[]string{"id", "name", "age", "version"},
+				[]interface{}{1, "a", 2, 3},
+				[]interface{}{2, "b", 2, 3},
+			)
+			r, err := DbNewRepo("t_user", "id", dbTestUser{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var users []dbTestUser
+			err = r.ListByIDs(context.Background(), f.DB, tt.ids, &users)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (len(f.Records()) != 0) != tt.wantSQL {
+				t.Fatalf("records = %v", f.Records())
+			}
+			if tt.wantSQL && len(users) != 2 {
+				t.Fatalf("users = %v", users)
+			}
+		})
+	}
+}
@@ -0,0 +1,495 @@
+package mcommon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-sql-driver/mysql"
+)
+
+// 按时间分表的周期
+const (
+	DbShardDay   = 1
+	DbShardMonth = 2
+	DbShardYear  = 3
+)
+
+// DbShard 分表路由, 根据分表键获取实际表名
+type DbShard struct {
+	table      string
+	timeUnit   int64
+	hashCount  int64
+	hashWidth  int
+	hashFormat string
+}
+
+// dbShardTables 已创建的分表路由, 逻辑表名 -> 分表路由, 用于从实际表名找回逻辑表的软删除和租户设置
+var dbShardTables = map[string]*DbShard{}
+
+// dbShardLock 分表路由配置锁
+var dbShardLock sync.RWMutex
+
+// dbShardRegister 记录分表路由
+func dbShardRegister(s *DbShard) {
+	dbShardLock.Lock()
+	defer dbShardLock.Unlock()
+	dbShardTables[s.table] = s
+}
+
+// dbShardLogicalTable 根据实际表名获取逻辑表名, 不是分表时返回原表名
+func dbShardLogicalTable(table string) string {
+	i := strings.LastIndex(table, "_")
+	if i <= 0 {
+		return table
+	}
+	suffix := table[i+1:]
+	if suffix == "" || strings.Trim(suffix, "0123456789") != "" {
+		return table
+	}
+	dbShardLock.RLock()
+	s, ok := dbShardTables[table[:i]]
+	dbShardLock.RUnlock()
+	if !ok {
+		return table
+	}
+	if s.hashCount > 0 {
+		index, err := strconv.ParseInt(suffix, 10, 64)
+		if err != nil || len(suffix) != s.hashWidth || index >= s.hashCount {
+			return table
+		}
+		return s.table
+	}
+	width := 6
+	switch s.timeUnit {
+	case DbShardDay:
+		width = 8
+	case DbShardYear:
+		width = 4
+	}
+	if len(suffix) != width {
+		return table
+	}
+	return s.table
+}
+
+// DbNewTimeShard 创建按时间分表, 日/月/年分别对应 table_20060102, table_200601, table_2006
+// 逻辑表的软删除和租户设置同样作用于分表
+func DbNewTimeShard(table string, timeUnit int64) *DbShard {
+	s := &DbShard{
+		table:    table,
+		timeUnit: timeUnit,
+	}
+	dbShardRegister(s)
+	return s
+}
+
+// DbNewHashShard 创建按整数取模分表, 表名后缀按分表数补零, 如16张表为 table_00 - table_15
+// 逻辑表的软删除和租户设置同样作用于分表
+func DbNewHashShard(table string, count int64) (*DbShard, error) {
+	if count <= 0 {
+		return nil, fmt.Errorf("shard %s count error: %d", table, count)
+	}
+	width := len(strconv.FormatInt(count-1, 10))
+	if width < 2 {
+		width = 2
+	}
+	s := &DbShard{
+		table:      table,
+		hashCount:  count,
+		hashWidth:  width,
+		hashFormat: fmt.Sprintf("%%s_%%0%dd", width),
+	}
+	dbShardRegister(s)
+	return s, nil
+}
+
+// TableByTime 根据时间获取表名
+func (s *DbShard) TableByTime(t time.Time) string {
+	switch s.timeUnit {
+	case DbShardDay:
+		return s.table + "_" + t.Format("20060102")
+	case DbShardYear:
+		return s.table + "_" + t.Format("2006")
+	default:
+		return s.table + "_" + t.Format("200601")
+	}
+}
+
+// TablesByTimeRange 获取时间范围内的所有表名, 包含开始和结束时间所在的表, 按时间倒序
+// 结束时间转换到开始时间的时区后计算
+func (s *DbShard) TablesByTimeRange(start time.Time, end time.Time) []string {
+	var tables []string
+	if end.Before(start) {
+		return tables
+	}
+	endTime := s.truncateTime(end.In(start.Location()))
+	for t := s.truncateTime(start); !t.After(endTime); {
+		tables = append([]string{s.TableByTime(t)}, tables...)
+		switch s.timeUnit {
+		case DbShardDay:
+			t = t.AddDate(0, 0, 1)
+		case DbShardYear:
+			t = t.AddDate(1, 0, 0)
+		default:
+			t = t.AddDate(0, 1, 0)
+		}
+	}
+	return tables
+}
+
+// truncateTime 时间取整到周期开始
+func (s *DbShard) truncateTime(t time.Time) time.Time {
+	switch s.timeUnit {
+	case DbShardDay:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	case DbShardYear:
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, t.Location())
+	default:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	}
+}
+
+// TableByHash 根据整数键获取表名, 负数使用绝对值取模
+func (s *DbShard) TableByHash(key int64) string {
+	// 先取模再取绝对值, 避免最小的负数取绝对值溢出
+	index := key % s.hashCount
+	if index < 0 {
+		index = -index
+	}
+	return fmt.Sprintf(s.hashFormat, s.table, index)
+}
+
+// TablesAll 获取取模分表的所有表名
+func (s *DbShard) TablesAll() []string {
+	tables := make([]string, s.hashCount)
+	for i := int64(0); i < s.hashCount; i++ {
+		tables[i] = fmt.Sprintf(s.hashFormat, s.table, i)
+	}
+	return tables
+}
+
+// dbShardCreateRegexp 建表语句中的表名
+var dbShardCreateRegexp = regexp.MustCompile("(?i)CREATE\\s+TABLE\\s+(IF\\s+NOT\\s+EXISTS\\s+)?`?[\\w.]+`?")
+
+// CreateTables 根据逻辑表的建表语句创建不存在的分表
+func (s *DbShard) CreateTables(ctx context.Context, tx DbExeAble, templateSQL string, tables []string) error {
+	if !dbShardCreateRegexp.MatchString(templateSQL) {
+		return fmt.Errorf("no create table in template: %s", s.table)
+	}
+	existTables, err := dbShowTables(ctx, tx)
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		if IsStringInSlice(existTables, table) {
+			continue
+		}
+		createSQL := dbShardCreateRegexp.ReplaceAllLiteralString(templateSQL, "CREATE TABLE IF NOT EXISTS `"+table+"`")
+		// 建表语句中的默认值和注释可能包含 :, 不解析命名参数
+		_, err = dbExecRaw(ctx, tx, createSQL)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shardQuery 生成在分表上执行的查询, 分页改为在合并后处理
+func (s *DbShard) shardQuery(q *selectData, table string) *selectData {
+	shardQ := *q
+	fields := strings.Fields(q.from)
+	fields[0] = table
+	shardQ.from = strings.Join(fields, " ")
+	shardQ.offset = 0
+	if q.limit > 0 {
+		shardQ.limit = q.offset + q.limit
+	}
+	return &shardQ
+}
+
+// dbShardIsNoTable 是否为表不存在的错误
+func dbShardIsNoTable(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == MysqlErrNoSuchTable
+}
+
+// SelectRows 在多个分表上执行查询, 合并后按查询的排序重新排序并分页, 跳过不存在的分表
+func (s *DbShard) SelectRows(ctx context.Context, tx DbExeAble, q *selectData, tables []string) ([]gin.H, error) {
+	var allRows []gin.H
+	for _, table := range tables {
+		query, argMap, err := s.shardQuery(q, table).ToSQL()
+		if err != nil {
+			return nil, err
+		}
+		rows, err := DbNamedRowsContent(ctx, tx, string(query), argMap)
+		if dbShardIsNoTable(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		allRows = append(allRows, rows...)
+	}
+	orders := dbShardOrders(q)
+	if len(orders) > 0 {
+		sort.SliceStable(allRows, func(i, j int) bool {
+			for _, order := range orders {
+				c := dbShardCompare(reflect.ValueOf(allRows[i][order.column]), reflect.ValueOf(allRows[j][order.column]))
+				if c != 0 {
+					return (c < 0) != order.isDesc
+				}
+			}
+			return false
+		})
+	}
+	start, end := dbShardPage(q, len(allRows))
+	return allRows[start:end], nil
+}
+
+// Select 在多个分表上执行查询, 合并到结构体切片后按查询的排序重新排序并分页, 跳过不存在的分表
+// 排序列需要在结构体中有对应的db标签
+func (s *DbShard) Select(ctx context.Context, tx DbExeAble, q *selectData, tables []string, dest interface{}) error {
+	destValue := reflect.ValueOf(dest)
+	if destValue.Kind() != reflect.Ptr || destValue.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("shard select dest not slice pointer: %T", dest)
+	}
+	sliceValue := destValue.Elem()
+	allRows := reflect.MakeSlice(sliceValue.Type(), 0, 0)
+	for _, table := range tables {
+		query, argMap, err := s.shardQuery(q, table).ToSQL()
+		if err != nil {
+			return err
+		}
+		rows := reflect.New(sliceValue.Type())
+		err = DbSelectNamedContent(ctx, tx, rows.Interface(), string(query), argMap)
+		if dbShardIsNoTable(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		allRows = reflect.AppendSlice(allRows, rows.Elem())
+	}
+	orders := dbShardOrders(q)
+	if len(orders) > 0 {
+		elemType := sliceValue.Type().Elem()
+		for elemType.Kind() == reflect.Ptr {
+			elemType = elemType.Elem()
+		}
+		fieldIndexes := make([][]int, len(orders))
+		for i, order := range orders {
+			fieldIndex, ok := dbShardFieldIndex(elemType, order.column)
+			if !ok {
+				return fmt.Errorf("shard select no order field: %s", order.column)
+			}
+			fieldIndexes[i] = fieldIndex
+		}
+		sort.SliceStable(allRows.Interface(), func(i, j int) bool {
+			vi := reflect.Indirect(allRows.Index(i))
+			vj := reflect.Indirect(allRows.Index(j))
+			for k, order := range orders {
+				c := dbShardCompare(vi.FieldByIndex(fieldIndexes[k]), vj.FieldByIndex(fieldIndexes[k]))
+				if c != 0 {
+					return (c < 0) != order.isDesc
+				}
+			}
+			return false
+		})
+	}
+	start, end := dbShardPage(q, allRows.Len())
+	sliceValue.Set(allRows.Slice(start, end))
+	return nil
+}
+
+// dbShardOrder 排序列
+type dbShardOrder struct {
+	column string
+	isDesc bool
+}
+
+// dbShardOrders 获取查询的排序列, 去掉表名前缀
+func dbShardOrders(q *selectData) []dbShardOrder {
+	var orders []dbShardOrder
+	for _, orderByPart := range q.orderByParts {
+		var order dbShardOrder
+		switch o := orderByPart.(type) {
+		case QueryDesc:
+			order.column = string(o)
+			order.isDesc = true
+		case QueryAsc:
+			order.column = string(o)
+		default:
+			continue
+		}
+		if i := strings.LastIndex(order.column, "."); i >= 0 {
+			order.column = order.column[i+1:]
+		}
+		order.column = strings.Trim(order.column, "`")
+		orders = append(orders, order)
+	}
+	return orders
+}
+
+// dbShardPage 获取合并后的分页范围
+func dbShardPage(q *selectData, l int) (int, int) {
+	start := int(q.offset)
+	if start > l {
+		start = l
+	}
+	end := l
+	if q.limit > 0 && start+int(q.limit) < end {
+		end = start + int(q.limit)
+	}
+	return start, end
+}
+
+// dbShardFieldIndex 根据db标签获取字段
+func dbShardFieldIndex(rt reflect.Type, column string) ([]int, bool) {
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		tag := strings.Split(f.Tag.Get("db"), ",")[0]
+		if tag == column {
+			return []int{i}, true
+		}
+		if tag == "" && f.Anonymous && f.Type.Kind() == reflect.Struct {
+			index, ok := dbShardFieldIndex(f.Type, column)
+			if ok {
+				return append([]int{i}, index...), true
+			}
+		}
+	}
+	return nil, false
+}
+
+// dbShardCompare 比较两个值, 支持数字, 字符串, 时间和 sql.Null 类型
+func dbShardCompare(a reflect.Value, b reflect.Value) int {
+	a = dbShardCompareValue(a)
+	b = dbShardCompareValue(b)
+	if !a.IsValid() || !b.IsValid() {
+		// NULL 排在最前
+		switch {
+		case !a.IsValid() && !b.IsValid():
+			return 0
+		case !a.IsValid():
+			return -1
+		default:
+			return 1
+		}
+	}
+	if na, ok := a.Interface().(json.Number); ok {
+		if nb, ok := b.Interface().(json.Number); ok {
+			fa, errA := na.Float64()
+			fb, errB := nb.Float64()
+			if errA == nil && errB == nil {
+				return dbShardCompareFloat(fa, fb)
+			}
+		}
+	}
+	switch {
+	case dbShardIsInt(a) && dbShardIsInt(b):
+		switch {
+		case a.Int() < b.Int():
+			return -1
+		case a.Int() > b.Int():
+			return 1
+		}
+		return 0
+	case dbShardIsUint(a) && dbShardIsUint(b):
+		switch {
+		case a.Uint() < b.Uint():
+			return -1
+		case a.Uint() > b.Uint():
+			return 1
+		}
+		return 0
+	case dbShardIsNumber(a) && dbShardIsNumber(b):
+		return dbShardCompareFloat(dbShardFloat(a), dbShardFloat(b))
+	}
+	if ta, ok := a.Interface().(time.Time); ok {
+		if tb, ok := b.Interface().(time.Time); ok {
+			switch {
+			case ta.Before(tb):
+				return -1
+			case ta.After(tb):
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(fmt.Sprint(a.Interface()), fmt.Sprint(b.Interface()))
+}
+
+// dbShardCompareValue 取出指针, 接口和 sql.Null 类型中的值, NULL 返回无效值
+func dbShardCompareValue(v reflect.Value) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	if v.IsValid() && v.Kind() == reflect.Struct {
+		valid := v.FieldByName("Valid")
+		if valid.IsValid() && valid.Kind() == reflect.Bool && v.NumField() == 2 {
+			if !valid.Bool() {
+				return reflect.Value{}
+			}
+			return v.Field(0)
+		}
+	}
+	return v
+}
+
+// dbShardIsInt 是否为有符号整数
+func dbShardIsInt(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	}
+	return false
+}
+
+// dbShardIsUint 是否为无符号整数
+func dbShardIsUint(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// dbShardIsNumber 是否为数字
+func dbShardIsNumber(v reflect.Value) bool {
+	return dbShardIsInt(v) || dbShardIsUint(v) || v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64
+}
+
+// dbShardFloat 数字转换为浮点数
+func dbShardFloat(v reflect.Value) float64 {
+	switch {
+	case dbShardIsInt(v):
+		return float64(v.Int())
+	case dbShardIsUint(v):
+		return float64(v.Uint())
+	}
+	return v.Float()
+}
+
+// dbShardCompareFloat 比较数字
+func dbShardCompareFloat(a float64, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
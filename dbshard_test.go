@@ -0,0 +1,267 @@
+package mcommon
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestDbNewHashShard(t *testing.T) {
+	tests := []struct {
+		count   int64
+		key     int64
+		want    string
+		wantErr bool
+	}{
+		{count: 16, key: 17, want: "t_log_01"},
+		{count: 16, key: -17, want: "t_log_01"},
+		{count: 16, key: math.MinInt64, want: "t_log_00"},
+		{count: 3, key: -5, want: "t_log_02"},
+		{count: 128, key: 5, want: "t_log_005"},
+		{count: 0, wantErr: true},
+		{count: -1, wantErr: true},
+	}
+	for _, tt := range tests {
+		s, err := DbNewHashShard("t_log", tt.count)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("count %d err = %v, wantErr %v", tt.count, err, tt.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+		got := s.TableByHash(tt.key)
+		if got != tt.want {
+			t.Errorf("count %d key %d table = %s, want %s", tt.count, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestDbShardTablesByTimeRange(t *testing.T) {
+	start := time.Date(2020, 11, 15, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC)
+	cst := time.FixedZone("CST", 8*3600)
+	tests := []struct {
+		unit  int64
+		start time.Time
+		end   time.Time
+		want  []string
+	}{
+		{DbShardMonth, start, end, []string{"t_log_202102", "t_log_202101", "t_log_202012", "t_log_202011"}},
+		{DbShardYear, start, end, []string{"t_log_2021", "t_log_2020"}},
+		{DbShardDay, start, start.AddDate(0, 0, 1), []string{"t_log_20201116", "t_log_20201115"}},
+		{DbShardMonth, start, start.AddDate(0, 0, -1), nil},
+		// 结束时间按开始时间的时区计算
+		{
+			DbShardDay,
+			time.Date(2024, 1, 2, 1, 0, 0, 0, cst),
+			time.Date(2024, 1, 1, 18, 0, 0, 0, time.UTC),
+			[]string{"t_log_20240102"},
+		},
+		{
+			DbShardDay,
+			time.Date(2024, 1, 1, 18, 0, 0, 0, time.UTC),
+			time.Date(2024, 1, 2, 3, 0, 0, 0, cst),
+			[]string{"t_log_20240101"},
+		},
+	}
+	for _, tt := range tests {
+		got := DbNewTimeShard("t_log", tt.unit).TablesByTimeRange(tt.start, tt.end)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("unit %d tables = %v, want %v", tt.unit, got, tt.want)
+		}
+	}
+}
+
+func TestDbShardCreateTables(t *testing.T) {
+	f := DbNewFake()
+	defer f.Close()
+	f.Expect(`information_schema\.tables`).WillReturnRows(
+		[]string{"table_name", "table_type"},
+		[]interface{}{"t_log_202101", "BASE TABLE"},
+	)
+	f.Expect(`^CREATE TABLE IF NOT EXISTS`).WillReturnResult(0, 0)
+	template := "CREATE TABLE `t_log` (\n" +
+		"  `id` int NOT NULL,\n" +
+		"  `created_at` datetime NOT NULL DEFAULT '2020-01-01 00:00:00' COMMENT 'a:b',\n" +
+		"  PRIMARY KEY (`id`)\n" +
+		")"
+	s := DbNewTimeShard("t_log", DbShardMonth)
+	err := s.CreateTables(context.Background(), f.DB, template, []string{"t_log_202102", "t_log_202101"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var creates []string
+	for _, r := range f.Records() {
+		if strings.HasPrefix(r.Query, "CREATE") {
+			creates = append(creates, r.Query)
+		}
+	}
+	if len(creates) != 1 || !strings.HasPrefix(creates[0], "CREATE TABLE IF NOT EXISTS `t_log_202102` (") ||
+		!strings.Contains(creates[0], "'2020-01-01 00:00:00' COMMENT 'a:b'") {
+		t.Fatalf("creates = %v", creates)
+	}
+}
+
+func TestDbShardSelectRows(t *testing.T) {
+	errNoTable := &mysql.MySQLError{Number: MysqlErrNoSuchTable, Message: "no table"}
+	type row struct {
+		ID int64 `db:"id"`
+	}
+	tests := []struct {
+		name    string
+		setup   func(f *DbFake)
+		limit   int64
+		offset  int64
+		want    []int64
+		wantErr bool
+	}{
+		{
+			name: "merge",
+			setup: func(f *DbFake) {
+				f.Expect(`t_log_202102`).WillReturnRows([]string{"id"}, []interface{}{4}, []interface{}{1})
+				f.Expect(`t_log_202101`).WillReturnRows([]string{"id"}, []interface{}{3}, []interface{}{2})
+			},
+			want: []int64{4, 3, 2, 1},
+		},
+		{
+			name: "page",
+			setup: func(f *DbFake) {
+				f.Expect(`t_log_202102`).WillReturnRows([]string{"id"}, []interface{}{4}, []interface{}{1})
+				f.Expect(`t_log_202101`).WillReturnRows([]string{"id"}, []interface{}{3}, []interface{}{2})
+			},
+			limit:  2,
+			offset: 1,
+			want:   []int64{3, 2},
+		},
+		{
+			name: "missing table",
+			setup: func(f *DbFake) {
+				f.Expect(`t_log_202102`).WillReturnError(errNoTable)
+				f.Expect(`t_log_202101`).WillReturnRows([]string{"id"}, []interface{}{3})
+			},
+			want: []int64{3},
+		},
+		{
+			name: "other error",
+			setup: func(f *DbFake) {
+				f.Expect(`t_log_202102`).WillReturnError(&mysql.MySQLError{Number: 1064})
+				f.Expect(`t_log_202101`).WillReturnRows([]string{"id"}, []interface{}{3})
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := DbNewFake()
+			defer f.Close()
+			tt.setup(f)
+			s := DbNewTimeShard("t_log", DbShardMonth)
+			tables := []string{"t_log_202102", "t_log_202101"}
+			q := QuerySelect(QueryColumn("id")).From("t_log").OrderBy(QueryDesc("id"))
+			if tt.limit > 0 {
+				q.Limit(tt.limit).Offset(tt.offset)
+			}
+			rows, err := s.SelectRows(context.Background(), f.DB, q, tables)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("rows err = %v, wantErr %v", err, tt.wantErr)
+			}
+			var got []int64
+			for _, row := range rows {
+				got = append(got, row["id"].(int64))
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("rows = %v, want %v", got, tt.want)
+			}
+
+			var structRows []row
+			err = s.Select(context.Background(), f.DB, q, tables, &structRows)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("select err = %v, wantErr %v", err, tt.wantErr)
+			}
+			got = nil
+			for _, row := range structRows {
+				got = append(got, row.ID)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("select = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDbShardLogicalTable(t *testing.T) {
+	_, err := DbNewHashShard("t_hash", 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	DbNewTimeShard("t_day", DbShardDay)
+	tests := []struct {
+		table string
+		want  string
+	}{
+		{"t_hash_03", "t_hash"},
+		{"t_hash_15", "t_hash"},
+		{"t_hash_16", "t_hash_16"},
+		{"t_hash_3", "t_hash_3"},
+		{"t_hash", "t_hash"},
+		{"t_day_20210102", "t_day"},
+		{"t_day_202101", "t_day_202101"},
+		{"t_other_01", "t_other_01"},
+	}
+	for _, tt := range tests {
+		got := dbShardLogicalTable(tt.table)
+		if got != tt.want {
+			t.Errorf("logical table %s = %s, want %s", tt.table, got, tt.want)
+		}
+	}
+}
+
+func TestDbShardSoftDeleteTenant(t *testing.T) {
+	QuerySetSoftDelete("t_shard", "deleted_at")
+	defer QueryRemoveSoftDelete("t_shard")
+	QuerySetTenantTable("t_shard", "tenant_id")
+	defer QueryRemoveTenantTable("t_shard")
+	s, err := DbNewHashShard("t_shard", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenant := DbWithTenant(context.Background(), int64(7))
+
+	f := DbNewFake()
+	defer f.Close()
+	f.Expect(`(?s)FROM\s+t_shard_01.*t_shard_01\.deleted_at IS NULL.*t_shard_01\.tenant_id=\?`).
+		WithArgs(int64(7)).
+		WillReturnRows([]string{"id"}, []interface{}{1})
+	f.Expect(`(?s)FROM\s+t_shard_00.*t_shard_00\.deleted_at IS NULL.*t_shard_00\.tenant_id=\?`).
+		WithArgs(int64(7)).
+		WillReturnRows([]string{"id"}, []interface{}{2})
+	q := QuerySelect(QueryColumn("id")).From("t_shard")
+	rows, err := s.SelectRows(tenant, f.DB, q, []string{"t_shard_01", "t_shard_00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("rows = %v", rows)
+	}
+
+	tests := []struct {
+		query   string
+		wantErr error
+	}{
+		{"SELECT * FROM t_shard_01", ErrDbTenantUnscoped},
+		{"SELECT * FROM t_user u, `t_shard_03` s", ErrDbTenantUnscoped},
+		{"SELECT * FROM t_shard_04", nil},
+		{"SELECT * FROM t_shard_1", nil},
+	}
+	for _, tt := range tests {
+		err := dbTenantCheck(tenant, tt.query)
+		if err != tt.wantErr {
+			t.Errorf("check %q err = %v, want %v", tt.query, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,363 @@
+package mcommon
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/schemalex/schemalex"
+	"github.com/schemalex/schemalex/format"
+	"github.com/schemalex/schemalex/model"
+)
+
+// 结构差异类型
+const (
+	DbDriftAdded   = "added"
+	DbDriftRemoved = "removed"
+	DbDriftChanged = "changed"
+)
+
+// DbSnapshot 表结构快照, 表名 -> 规范化的建表语句
+type DbSnapshot map[string]string
+
+// DbSchemaDrift 两个快照的差异
+type DbSchemaDrift struct {
+	Tables []*DbTableDrift
+}
+
+// DbTableDrift 表的差异
+type DbTableDrift struct {
+	Table string
+	// Change 表新增, 删除或修改
+	Change  string
+	Columns []*DbItemDrift
+	Indexes []*DbItemDrift
+	Options []*DbItemDrift
+}
+
+// DbItemDrift 列, 索引或表选项的差异
+type DbItemDrift struct {
+	Name   string
+	Change string
+	From   string
+	To     string
+}
+
+// HasDrift 是否有差异
+func (d *DbSchemaDrift) HasDrift() bool {
+	return len(d.Tables) > 0
+}
+
+// String 输出差异
+func (d *DbSchemaDrift) String() string {
+	var b strings.Builder
+	for _, table := range d.Tables {
+		b.WriteString(fmt.Sprintf("table %s %s\n", table.Table, table.Change))
+		for _, items := range []struct {
+			kind  string
+			items []*DbItemDrift
+		}{
+			{"column", table.Columns},
+			{"index", table.Indexes},
+			{"option", table.Options},
+		} {
+			for _, item := range items.items {
+				b.WriteString(fmt.Sprintf("  %s %s %s", items.kind, item.Name, item.Change))
+				if item.From != "" {
+					b.WriteString("\n    - ")
+					b.WriteString(item.From)
+				}
+				if item.To != "" {
+					b.WriteString("\n    + ")
+					b.WriteString(item.To)
+				}
+				b.WriteString("\n")
+			}
+		}
+	}
+	return b.String()
+}
+
+// DbSnapshotFromDb 获取数据库中所有表的快照
+func DbSnapshotFromDb(ctx context.Context, tx DbExeAble, opts *DbStructNormalizeOptions) (DbSnapshot, error) {
+	tableNames, err := dbShowTables(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := DbSnapshot{}
+	for _, tableName := range tableNames {
+		tableSQL, ok, err := dbShowCreateTable(ctx, tx, tableName)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			snapshot[tableName] = DbStructNormalize(tableSQL, opts)
+		}
+	}
+	return snapshot, nil
+}
+
+// DbSnapshotFromDir 从sql文件或目录中读取快照, 每个建表语句为一个表
+func DbSnapshotFromDir(p string, opts *DbStructNormalizeOptions) (DbSnapshot, error) {
+	files, err := dbStructSQLFiles(p)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := DbSnapshot{}
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, stmt := range dbSplitStatements(string(content)) {
+			m := dbDiffCreateTableReg.FindStringSubmatch(stmt)
+			if m == nil {
+				continue
+			}
+			if _, ok := snapshot[m[1]]; ok {
+				return nil, fmt.Errorf("duplicate table: %s", m[1])
+			}
+			snapshot[m[1]] = DbStructNormalize(stmt, opts)
+		}
+	}
+	return snapshot, nil
+}
+
+// DbSnapshotManifest 快照目录中记录已写入表文件的清单
+const DbSnapshotManifest = ".snapshot_tables"
+
+// Write 写入目录, 每个表一个 <表名>.sql 文件, 并在清单中记录写入的文件
+// 只删除之前的快照写入且已不存在的表文件, 目录中的其他文件不会被删除
+func (s DbSnapshot) Write(dir string) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	manifestPath := filepath.Join(dir, DbSnapshotManifest)
+	manifest, err := ioutil.ReadFile(manifestPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, name := range strings.Split(string(manifest), "\n") {
+		name = strings.TrimSpace(name)
+		// 只处理清单中当前目录下的表文件
+		if name == "" || filepath.Base(name) != name || !strings.HasSuffix(name, ".sql") {
+			continue
+		}
+		if _, ok := s[strings.TrimSuffix(name, ".sql")]; ok {
+			continue
+		}
+		err = os.Remove(filepath.Join(dir, name))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	var names []string
+	for _, tableName := range s.Tables() {
+		err = ioutil.WriteFile(filepath.Join(dir, tableName+".sql"), []byte(s[tableName]+"\n"), 0644)
+		if err != nil {
+			return err
+		}
+		names = append(names, tableName+".sql")
+	}
+	return ioutil.WriteFile(manifestPath, []byte(strings.Join(names, "\n")+"\n"), 0644)
+}
+
+// Tables 表名, 按名称排序
+func (s DbSnapshot) Tables() []string {
+	var tableNames []string
+	for tableName := range s {
+		tableNames = append(tableNames, tableName)
+	}
+	sort.Strings(tableNames)
+	return tableNames
+}
+
+// DbSnapshotExport 导出数据库中所有表的快照到目录
+func DbSnapshotExport(ctx context.Context, tx DbExeAble, dir string, opts *DbStructNormalizeOptions) (DbSnapshot, error) {
+	snapshot, err := DbSnapshotFromDb(ctx, tx, opts)
+	if err != nil {
+		return nil, err
+	}
+	err = snapshot.Write(dir)
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// DbSnapshotDriftDb 比较目录中的快照和数据库
+func DbSnapshotDriftDb(ctx context.Context, tx DbExeAble, dir string, opts *DbStructNormalizeOptions) (*DbSchemaDrift, error) {
+	from, err := DbSnapshotFromDir(dir, opts)
+	if err != nil {
+		return nil, err
+	}
+	to, err := DbSnapshotFromDb(ctx, tx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return DbSnapshotDrift(from, to)
+}
+
+// DbSnapshotDrift 比较两个快照, 返回从 from 到 to 的差异
+func DbSnapshotDrift(from DbSnapshot, to DbSnapshot) (*DbSchemaDrift, error) {
+	tableNameMap := map[string]bool{}
+	for tableName := range from {
+		tableNameMap[tableName] = true
+	}
+	for tableName := range to {
+		tableNameMap[tableName] = true
+	}
+	var tableNames []string
+	for tableName := range tableNameMap {
+		tableNames = append(tableNames, tableName)
+	}
+	sort.Strings(tableNames)
+
+	drift := &DbSchemaDrift{}
+	for _, tableName := range tableNames {
+		fromItems, err := dbSnapshotItems(from[tableName])
+		if err != nil {
+			return nil, fmt.Errorf("table %s: %w", tableName, err)
+		}
+		toItems, err := dbSnapshotItems(to[tableName])
+		if err != nil {
+			return nil, fmt.Errorf("table %s: %w", tableName, err)
+		}
+		tableDrift := &DbTableDrift{
+			Table:   tableName,
+			Change:  DbDriftChanged,
+			Columns: dbSnapshotItemsDrift(fromItems.columnNames, fromItems.columns, toItems.columnNames, toItems.columns),
+			Indexes: dbSnapshotItemsDrift(fromItems.indexNames, fromItems.indexes, toItems.indexNames, toItems.indexes),
+			Options: dbSnapshotItemsDrift(fromItems.optionNames, fromItems.options, toItems.optionNames, toItems.options),
+		}
+		switch {
+		case from[tableName] == "":
+			tableDrift.Change = DbDriftAdded
+		case to[tableName] == "":
+			tableDrift.Change = DbDriftRemoved
+		case len(tableDrift.Columns) == 0 && len(tableDrift.Indexes) == 0 && len(tableDrift.Options) == 0:
+			continue
+		}
+		drift.Tables = append(drift.Tables, tableDrift)
+	}
+	return drift, nil
+}
+
+// dbSnapshotTableItems 表中的列, 索引和表选项的定义
+type dbSnapshotTableItems struct {
+	columnNames []string
+	columns     map[string]string
+	indexNames  []string
+	indexes     map[string]string
+	optionNames []string
+	options     map[string]string
+}
+
+// dbSnapshotItems 解析建表语句, 获取格式化后的列, 索引和表选项
+func dbSnapshotItems(tableSQL string) (*dbSnapshotTableItems, error) {
+	items := &dbSnapshotTableItems{
+		columns: map[string]string{},
+		indexes: map[string]string{},
+		options: map[string]string{},
+	}
+	if tableSQL == "" {
+		return items, nil
+	}
+	stmts, err := schemalex.New().ParseString(tableSQL)
+	if err != nil {
+		return nil, err
+	}
+	for _, stmt := range stmts {
+		table, ok := stmt.(model.Table)
+		if !ok {
+			continue
+		}
+		table, _ = table.Normalize()
+		for column := range table.Columns() {
+			def, err := dbSnapshotFormat(column)
+			if err != nil {
+				return nil, err
+			}
+			items.columnNames = append(items.columnNames, column.Name())
+			items.columns[column.Name()] = def
+		}
+		for index := range table.Indexes() {
+			def, err := dbSnapshotFormat(index)
+			if err != nil {
+				return nil, err
+			}
+			name := def
+			switch {
+			case index.IsPrimaryKey():
+				name = "PRIMARY"
+			case index.HasName():
+				name = index.Name()
+			case index.HasSymbol():
+				name = index.Symbol()
+			}
+			items.indexNames = append(items.indexNames, name)
+			items.indexes[name] = def
+		}
+		for option := range table.Options() {
+			def, err := dbSnapshotFormat(option)
+			if err != nil {
+				return nil, err
+			}
+			name := strings.ToUpper(option.Key())
+			items.optionNames = append(items.optionNames, name)
+			items.options[name] = def
+		}
+	}
+	return items, nil
+}
+
+// dbSnapshotFormat 格式化列, 索引或表选项
+func dbSnapshotFormat(v interface{}) (string, error) {
+	var buf bytes.Buffer
+	err := format.SQL(&buf, v)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// dbSnapshotItemsDrift 比较两组定义, 按 from 中的顺序输出删除和修改, 再输出新增
+func dbSnapshotItemsDrift(fromNames []string, from map[string]string, toNames []string, to map[string]string) []*DbItemDrift {
+	var drifts []*DbItemDrift
+	for _, name := range fromNames {
+		toDef, ok := to[name]
+		if !ok {
+			drifts = append(drifts, &DbItemDrift{
+				Name:   name,
+				Change: DbDriftRemoved,
+				From:   from[name],
+			})
+			continue
+		}
+		if toDef != from[name] {
+			drifts = append(drifts, &DbItemDrift{
+				Name:   name,
+				Change: DbDriftChanged,
+				From:   from[name],
+				To:     toDef,
+			})
+		}
+	}
+	for _, name := range toNames {
+		if _, ok := from[name]; ok {
+			continue
+		}
+		drifts = append(drifts, &DbItemDrift{
+			Name:   name,
+			Change: DbDriftAdded,
+			To:     to[name],
+		})
+	}
+	return drifts
+}
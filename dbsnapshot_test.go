@@ -0,0 +1,149 @@
+package mcommon
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestDbSnapshotWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcommon_snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(filepath.Join(dir, "custom.sql"), []byte("SELECT 1;\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tableA := "CREATE TABLE `t_a` (\n  `id` int NOT NULL\n);"
+	tableB := "CREATE TABLE `t_b` (\n  `id` int NOT NULL\n);"
+	tests := []struct {
+		name      string
+		snapshot  DbSnapshot
+		wantFiles []string
+	}{
+		{"first", DbSnapshot{"t_a": tableA, "t_b": tableB}, []string{DbSnapshotManifest, "custom.sql", "t_a.sql", "t_b.sql"}},
+		{"remove table", DbSnapshot{"t_a": tableA}, []string{DbSnapshotManifest, "custom.sql", "t_a.sql"}},
+		{"empty", DbSnapshot{}, []string{DbSnapshotManifest, "custom.sql"}},
+	}
+	for _, tt := range tests {
+		err = tt.snapshot.Write(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, file := range files {
+			names = append(names, file.Name())
+		}
+		sort.Strings(names)
+		if !reflect.DeepEqual(names, tt.wantFiles) {
+			t.Fatalf("%s files = %v, want %v", tt.name, names, tt.wantFiles)
+		}
+	}
+}
+
+func TestDbSnapshotFromDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcommon_snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	snapshot := DbSnapshot{
+		"t_a": DbStructNormalize("CREATE TABLE `t_a` (`id` int(11) NOT NULL, PRIMARY KEY (`id`))", nil),
+	}
+	err = snapshot.Write(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := DbSnapshotFromDir(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, snapshot) {
+		t.Fatalf("snapshot = %v, want %v", got, snapshot)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "dup.sql"), []byte("CREATE TABLE t_a (id int);"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = DbSnapshotFromDir(dir, nil)
+	if err == nil {
+		t.Fatal("want duplicate table error")
+	}
+}
+
+func TestDbSnapshotDrift(t *testing.T) {
+	from := DbSnapshot{
+		"t_a": "CREATE TABLE `t_a` (`id` int NOT NULL, `name` varchar(32), PRIMARY KEY (`id`)) ENGINE=InnoDB",
+		"t_b": "CREATE TABLE `t_b` (`id` int NOT NULL)",
+		"t_c": "CREATE TABLE `t_c` (`id` int NOT NULL)",
+	}
+	to := DbSnapshot{
+		"t_a": "CREATE TABLE `t_a` (`id` int NOT NULL, `name` varchar(64), `age` int, PRIMARY KEY (`id`), KEY `k_age` (`age`)) ENGINE=MyISAM",
+		"t_c": "CREATE TABLE `t_c` (`id` int NOT NULL)",
+		"t_d": "CREATE TABLE `t_d` (`id` int NOT NULL)",
+	}
+	drift, err := DbSnapshotDrift(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type item struct {
+		kind   string
+		name   string
+		change string
+	}
+	tests := []struct {
+		table  string
+		change string
+		items  []item
+	}{
+		{"t_a", DbDriftChanged, []item{
+			{"column", "name", DbDriftChanged},
+			{"column", "age", DbDriftAdded},
+			{"index", "k_age", DbDriftAdded},
+			{"option", "ENGINE", DbDriftChanged},
+		}},
+		{"t_b", DbDriftRemoved, []item{{"column", "id", DbDriftRemoved}}},
+		{"t_d", DbDriftAdded, []item{{"column", "id", DbDriftAdded}}},
+	}
+	if !drift.HasDrift() || len(drift.Tables) != len(tests) {
+		t.Fatalf("drift =\n%s", drift)
+	}
+	for i, tt := range tests {
+		table := drift.Tables[i]
+		if table.Table != tt.table || table.Change != tt.change {
+			t.Fatalf("table %d = %s %s, want %s %s", i, table.Table, table.Change, tt.table, tt.change)
+		}
+		var items []item
+		for _, kindItems := range []struct {
+			kind  string
+			items []*DbItemDrift
+		}{
+			{"column", table.Columns},
+			{"index", table.Indexes},
+			{"option", table.Options},
+		} {
+			for _, it := range kindItems.items {
+				items = append(items, item{kindItems.kind, it.Name, it.Change})
+			}
+		}
+		if !reflect.DeepEqual(items, tt.items) {
+			t.Errorf("table %s items = %v, want %v", tt.table, items, tt.items)
+		}
+	}
+	same, err := DbSnapshotDrift(from, from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if same.HasDrift() {
+		t.Fatalf("same drift =\n%s", same)
+	}
+}
@@ -3,37 +3,29 @@ package mcommon
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/schemalex/schemalex"
 	"github.com/schemalex/schemalex/diff"
+	"github.com/schemalex/schemalex/model"
 )
 
 // DbStructGetDiff 获取数据库更新指令
 func DbStructGetDiff(tx DbExeAble, tableNames []string, sqlFilePath string) (string, error) {
 	var dbSQLs []string
 	for _, tableName := range tableNames {
-		var row struct {
-			TableName string `db:"Table"`
-			TableSQL  string `db:"Create Table"`
-		}
-		ok, err := DbGetNamedContent(
-			context.Background(),
-			tx,
-			&row,
-			`SHOW CREATE TABLE `+tableName,
-			gin.H{},
-		)
+		tableSQL, ok, err := dbShowCreateTable(context.Background(), tx, tableName)
 		if err != nil {
-			if strings.Contains(err.Error(), "doesn't exist") {
-				continue
-			}
 			return "", err
 		}
 		if ok {
-			dbSQLs = append(dbSQLs, row.TableSQL+";")
+			dbSQLs = append(dbSQLs, tableSQL+";")
 		}
 	}
 	// 原始sql
@@ -48,10 +40,212 @@ func DbStructGetDiff(tx DbExeAble, tableNames []string, sqlFilePath string) (str
 	if err != nil {
 		return "", err
 	}
-	// 替换 AUTO_INCREMENT
+	return dbStructRemoveAutoIncrement(sqlDiff.String()), nil
+}
+
+// DbStructDiffOptions 获取数据库更新指令的选项
+type DbStructDiffOptions struct {
+	// Paths 目标sql文件或目录, 目录中读取所有 .sql 文件
+	Paths []string
+	// Tables 比较的表, 为空时比较目标sql中的所有表
+	Tables []string
+	// DropExtra 是否生成删除数据库中多余表的语句
+	DropExtra bool
+	// Normalize 比较前的规范化规则, 为空时不规范化, 使用 DbStructNormalizeDefault 启用所有规则
+	Normalize *DbStructNormalizeOptions
+}
+
+// DbStructDiffResult 数据库更新指令
+type DbStructDiffResult struct {
+	SQL string
+	// ExtraTables 数据库中存在但目标sql中没有的表
+	ExtraTables []string
+	// MissingTables 目标sql中存在但数据库中没有的表
+	MissingTables []string
+}
+
+// DbStructGetDiffContent 获取数据库更新指令
+// 从目标sql和数据库中自动发现表, 支持多个文件和目录
+// 没有读取到目标表时不允许 DropExtra, 避免删除数据库中的所有表
+func DbStructGetDiffContent(ctx context.Context, tx DbExeAble, opts *DbStructDiffOptions) (*DbStructDiffResult, error) {
+	if opts == nil {
+		opts = &DbStructDiffOptions{}
+	}
+	var toSQLs []string
+	for _, p := range opts.Paths {
+		files, err := dbStructSQLFiles(p)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			content, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			toSQLs = append(toSQLs, string(content))
+		}
+	}
+	p := schemalex.New()
+	toStmts, err := p.ParseString(dbStructNormalizeOpt(strings.Join(toSQLs, ";\n"), opts.Normalize))
+	if err != nil {
+		return nil, err
+	}
+	dbTableNames, err := dbShowTables(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	var toTables model.Stmts
+	toTableMap := map[string]bool{}
+	for _, stmt := range toStmts {
+		table, ok := stmt.(model.Table)
+		if !ok {
+			continue
+		}
+		if len(opts.Tables) > 0 && !IsStringInSlice(opts.Tables, table.Name()) {
+			continue
+		}
+		if toTableMap[table.Name()] {
+			return nil, fmt.Errorf("duplicate table: %s", table.Name())
+		}
+		toTableMap[table.Name()] = true
+		toTables = append(toTables, table)
+	}
+	if opts.DropExtra && len(toTables) == 0 {
+		return nil, fmt.Errorf("drop extra tables without target tables")
+	}
+	result := &DbStructDiffResult{}
+	var dbSQLs []string
+	dbTableMap := map[string]bool{}
+	for _, tableName := range dbTableNames {
+		dbTableMap[tableName] = true
+		if !toTableMap[tableName] {
+			if len(opts.Tables) > 0 && !IsStringInSlice(opts.Tables, tableName) {
+				continue
+			}
+			result.ExtraTables = append(result.ExtraTables, tableName)
+			if !opts.DropExtra {
+				continue
+			}
+		}
+		tableSQL, ok, err := dbShowCreateTable(ctx, tx, tableName)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			dbSQLs = append(dbSQLs, tableSQL+";")
+		}
+	}
+	for _, stmt := range toTables {
+		tableName := stmt.(model.Table).Name()
+		if !dbTableMap[tableName] {
+			result.MissingTables = append(result.MissingTables, tableName)
+		}
+	}
+	dbStmts, err := p.ParseString(dbStructNormalizeOpt(strings.Join(dbSQLs, "\n"), opts.Normalize))
+	if err != nil {
+		return nil, err
+	}
+	sqlDiff := new(bytes.Buffer)
+	err = diff.Statements(sqlDiff, dbStmts, toTables, diff.WithTransaction(true))
+	if err != nil {
+		return nil, err
+	}
+	result.SQL = dbStructRemoveAutoIncrement(sqlDiff.String())
+	return result, nil
+}
+
+// dbStructNormalizeOpt 设置了规范化规则时规范化sql
+func dbStructNormalizeOpt(sqlStr string, opts *DbStructNormalizeOptions) string {
+	if opts == nil {
+		return sqlStr
+	}
+	return DbStructNormalize(sqlStr, opts)
+}
+
+// dbStructSQLFiles 获取路径中的sql文件, 目录按文件名排序
+func dbStructSQLFiles(p string) ([]string, error) {
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{p}, nil
+	}
+	var files []string
+	err = filepath.Walk(p, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(strings.ToLower(info.Name()), ".sql") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// dbStructRemoveAutoIncrement 替换 AUTO_INCREMENT
+func dbStructRemoveAutoIncrement(sqlDiff string) string {
 	r, _ := regexp.Compile(`AUTO_INCREMENT\s*=\s*(\d)*\s*,`)
-	sqlDiffWithoutInc := r.ReplaceAllStringFunc(sqlDiff.String(), func(s string) string {
+	return r.ReplaceAllStringFunc(sqlDiff, func(s string) string {
 		return ""
 	})
-	return sqlDiffWithoutInc, nil
+}
+
+// dbShowCreateTable 获取建表语句, 表不存在时返回false
+func dbShowCreateTable(ctx context.Context, tx DbExeAble, tableName string) (string, bool, error) {
+	var row struct {
+		TableName string `db:"Table"`
+		TableSQL  string `db:"Create Table"`
+	}
+	ok, err := DbGetNamedContent(
+		ctx,
+		tx,
+		&row,
+		`SHOW CREATE TABLE `+tableName,
+		gin.H{},
+	)
+	if err != nil {
+		if dbShardIsNoTable(err) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return row.TableSQL, ok, nil
+}
+
+// dbShowTables 获取当前数据库的所有表名, 不包含视图
+func dbShowTables(ctx context.Context, tx DbExeAble) ([]string, error) {
+	var rows []struct {
+		TableName string `db:"table_name"`
+		TableType string `db:"table_type"`
+	}
+	err := DbSelectNamedContent(
+		ctx,
+		tx,
+		&rows,
+		`SELECT
+    table_name AS table_name,
+    table_type AS table_type
+FROM
+    information_schema.tables
+WHERE
+    table_schema=DATABASE()
+    AND table_type='BASE TABLE'
+ORDER BY
+    table_name`,
+		gin.H{},
+	)
+	if err != nil {
+		return nil, err
+	}
+	tableNames := make([]string, len(rows))
+	for i, row := range rows {
+		tableNames[i] = row.TableName
+	}
+	return tableNames, nil
 }
@@ -0,0 +1,186 @@
+package mcommon
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// dbTestStructFake 模拟数据库中的表
+func dbTestStructFake(tables map[string]string) *DbFake {
+	f := DbNewFake()
+	var names []string
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var rows [][]interface{}
+	for _, name := range names {
+		rows = append(rows, []interface{}{name, "BASE TABLE"})
+		f.Expect("^SHOW CREATE TABLE "+name+"$").
+			WillReturnRows([]string{"Table", "Create Table"}, []interface{}{name, tables[name]})
+	}
+	f.Expect(`information_schema\.tables`).WillReturnRows([]string{"table_name", "table_type"}, rows...)
+	return f
+}
+
+func TestDbStructGetDiffContent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcommon_struct")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(filepath.Join(dir, "t_a.sql"), []byte("CREATE TABLE `t_a` (\n"+
+		"  `id` int NOT NULL,\n"+
+		"  `name` varchar(32) NOT NULL,\n"+
+		"  PRIMARY KEY (`id`)\n"+
+		");\n"+
+		"CREATE TABLE `t_new` (\n"+
+		"  `id` int NOT NULL,\n"+
+		"  PRIMARY KEY (`id`)\n"+
+		");"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbTables := map[string]string{
+		"t_a":     "CREATE TABLE `t_a` (\n  `id` int NOT NULL,\n  PRIMARY KEY (`id`)\n) ENGINE=InnoDB",
+		"t_extra": "CREATE TABLE `t_extra` (\n  `id` int NOT NULL,\n  PRIMARY KEY (`id`)\n) ENGINE=InnoDB",
+	}
+	tests := []struct {
+		name        string
+		opts        *DbStructDiffOptions
+		wantErr     bool
+		want        []string
+		notWant     []string
+		wantExtra   []string
+		wantMissing []string
+	}{
+		{
+			name:      "nil opts",
+			wantExtra: []string{"t_a", "t_extra"},
+			notWant:   []string{"DROP TABLE", "ALTER TABLE"},
+		},
+		{
+			name:        "dir",
+			opts:        &DbStructDiffOptions{Paths: []string{dir}},
+			want:        []string{"ALTER TABLE `t_a` ADD COLUMN `name`", "CREATE TABLE `t_new`"},
+			notWant:     []string{"DROP TABLE"},
+			wantExtra:   []string{"t_extra"},
+			wantMissing: []string{"t_new"},
+		},
+		{
+			name:        "drop extra",
+			opts:        &DbStructDiffOptions{Paths: []string{dir}, DropExtra: true},
+			want:        []string{"DROP TABLE `t_extra`"},
+			wantExtra:   []string{"t_extra"},
+			wantMissing: []string{"t_new"},
+		},
+		{
+			name:    "tables",
+			opts:    &DbStructDiffOptions{Paths: []string{dir}, Tables: []string{"t_a"}, DropExtra: true},
+			want:    []string{"ADD COLUMN `name`"},
+			notWant: []string{"t_new", "t_extra"},
+		},
+		{
+			name:    "drop extra without paths",
+			opts:    &DbStructDiffOptions{DropExtra: true},
+			wantErr: true,
+		},
+		{
+			name:    "drop extra without tables",
+			opts:    &DbStructDiffOptions{Paths: []string{dir}, Tables: []string{"t_none"}, DropExtra: true},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := dbTestStructFake(dbTables)
+			defer f.Close()
+			result, err := DbStructGetDiffContent(context.Background(), f.DB, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			for _, s := range tt.want {
+				if !strings.Contains(result.SQL, s) {
+					t.Errorf("sql missing %q:\n%s", s, result.SQL)
+				}
+			}
+			for _, s := range tt.notWant {
+				if strings.Contains(result.SQL, s) {
+					t.Errorf("sql contains %q:\n%s", s, result.SQL)
+				}
+			}
+			if !reflect.DeepEqual(result.ExtraTables, tt.wantExtra) || !reflect.DeepEqual(result.MissingTables, tt.wantMissing) {
+				t.Fatalf("extra = %v, missing = %v", result.ExtraTables, result.MissingTables)
+			}
+		})
+	}
+}
+
+func TestDbStructGetDiff(t *testing.T) {
+	f, err := ioutil.TempFile("", "mcommon_struct_*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString("CREATE TABLE `t_a` (\n  `id` int NOT NULL,\n  `name` varchar(32),\n  PRIMARY KEY (`id`)\n) DEFAULT CHARSET=utf8mb4;")
+	_ = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := dbTestStructFake(map[string]string{
+		"t_a": "CREATE TABLE `t_a` (\n" +
+			"  `id` int(11) NOT NULL AUTO_INCREMENT,\n" +
+			"  `name` varchar(32) CHARACTER SET utf8mb4 DEFAULT NULL,\n" +
+			"  PRIMARY KEY (`id`)\n" +
+			") ENGINE=InnoDB AUTO_INCREMENT=3 DEFAULT CHARSET=utf8mb4",
+	})
+	defer fake.Close()
+	diffSQL, err := DbStructGetDiff(fake.DB, []string{"t_a"}, f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 不做规范化, 列字符集的差异保留
+	if !strings.Contains(diffSQL, "CHANGE COLUMN `name`") || strings.Contains(diffSQL, "AUTO_INCREMENT =") {
+		t.Fatalf("diff =\n%s", diffSQL)
+	}
+}
+
+func TestDbShowCreateTable(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		wantOk  bool
+		wantErr bool
+	}{
+		{"ok", nil, true, false},
+		{"no table", &mysql.MySQLError{Number: MysqlErrNoSuchTable, Message: "Table 't_user' doesn't exist"}, false, false},
+		{"other error", &mysql.MySQLError{Number: 1064, Message: "doesn't exist"}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := DbNewFake()
+			defer f.Close()
+			e := f.Expect(`^SHOW CREATE TABLE t_user$`)
+			if tt.err != nil {
+				e.WillReturnError(tt.err)
+			} else {
+				e.WillReturnRows([]string{"Table", "Create Table"}, []interface{}{"t_user", "CREATE TABLE `t_user` (\n)"})
+			}
+			_, ok, err := dbShowCreateTable(context.Background(), f.DB, "t_user")
+			if (err != nil) != tt.wantErr || ok != tt.wantOk {
+				t.Fatalf("ok = %v, err = %v, want %v %v", ok, err, tt.wantOk, tt.wantErr)
+			}
+		})
+	}
+}
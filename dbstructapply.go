@@ -0,0 +1,499 @@
+package mcommon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrDbDiffDestructive 包含破坏性的更新
+var ErrDbDiffDestructive = errors.New("db diff has destructive statements")
+
+// DbDiffKind 更新语句类型
+type DbDiffKind int
+
+// 更新语句类型
+const (
+	DbDiffOther DbDiffKind = iota
+	DbDiffCreateTable
+	DbDiffDropTable
+	DbDiffAddColumn
+	DbDiffDropColumn
+	DbDiffModifyColumn
+	DbDiffNarrowColumn
+	DbDiffAddIndex
+	DbDiffDropIndex
+)
+
+// dbDiffKindNames 类型名
+var dbDiffKindNames = map[DbDiffKind]string{
+	DbDiffOther:        "other",
+	DbDiffCreateTable:  "create table",
+	DbDiffDropTable:    "drop table",
+	DbDiffAddColumn:    "add column",
+	DbDiffDropColumn:   "drop column",
+	DbDiffModifyColumn: "modify column",
+	DbDiffNarrowColumn: "narrow column",
+	DbDiffAddIndex:     "add index",
+	DbDiffDropIndex:    "drop index",
+}
+
+// String 类型名
+func (k DbDiffKind) String() string {
+	name, ok := dbDiffKindNames[k]
+	if !ok {
+		return "kind(" + strconv.Itoa(int(k)) + ")"
+	}
+	return name
+}
+
+// DbDiffStatement 单条更新语句
+type DbDiffStatement struct {
+	SQL   string
+	Kind  DbDiffKind
+	Table string
+	// Name 列名或索引名
+	Name string
+	// OldType 修改列时数据库中的原类型
+	OldType string
+	// NewType 修改列时的新类型
+	NewType string
+	// Destructive 是否会丢失数据
+	Destructive bool
+}
+
+// DbDiffApplyOptions 执行更新的选项
+type DbDiffApplyOptions struct {
+	// AllowDestructive 允许删除表, 删除列, 缩小列类型
+	AllowDestructive bool
+	// DryRun 只分析并输出, 不执行
+	DryRun bool
+}
+
+// DbDiffApplyReport 执行更新的结果
+type DbDiffApplyReport struct {
+	Statements []*DbDiffStatement
+	// Refused 因为破坏性被拒绝的语句
+	Refused []*DbDiffStatement
+	// Executed 已执行的语句数
+	Executed int
+	DryRun   bool
+}
+
+// String 输出带分类注释的sql
+func (r *DbDiffApplyReport) String() string {
+	var b strings.Builder
+	for _, stmt := range r.Statements {
+		b.WriteString("-- ")
+		b.WriteString(stmt.Kind.String())
+		if stmt.Table != "" {
+			b.WriteString(" ")
+			b.WriteString(stmt.Table)
+			if stmt.Name != "" {
+				b.WriteString(".")
+				b.WriteString(stmt.Name)
+			}
+		}
+		if stmt.OldType != "" {
+			b.WriteString(fmt.Sprintf(" %s -> %s", stmt.OldType, stmt.NewType))
+		}
+		if stmt.Destructive {
+			b.WriteString(" [destructive]")
+		}
+		b.WriteString("\n")
+		b.WriteString(stmt.SQL)
+		b.WriteString(";\n")
+	}
+	return b.String()
+}
+
+var (
+	// dbDiffCreateTableReg 建表
+	dbDiffCreateTableReg = regexp.MustCompile("(?is)^CREATE\\s+TABLE\\s+(?:IF\\s+NOT\\s+EXISTS\\s+)?`?([\\w$]+)`?")
+	// dbDiffDropTableReg 删表
+	dbDiffDropTableReg = regexp.MustCompile("(?is)^DROP\\s+TABLE\\s+(?:IF\\s+EXISTS\\s+)?`?([\\w$]+)`?")
+	// dbDiffAlterTableReg 修改表
+	dbDiffAlterTableReg = regexp.MustCompile("(?is)^ALTER\\s+TABLE\\s+`?([\\w$]+)`?\\s+(.*)$")
+	// dbDiffAddColumnReg 添加列
+	dbDiffAddColumnReg = regexp.MustCompile("(?is)^ADD\\s+(?:COLUMN\\s+)?`([\\w$]+)`")
+	// dbDiffDropColumnReg 删除列
+	dbDiffDropColumnReg = regexp.MustCompile("(?is)^DROP\\s+(?:COLUMN\\s+)?`([\\w$]+)`")
+	// dbDiffChangeColumnReg 修改列
+	dbDiffChangeColumnReg = regexp.MustCompile("(?is)^CHANGE\\s+(?:COLUMN\\s+)?`([\\w$]+)`\\s+`[\\w$]+`\\s+(.*)$")
+	// dbDiffModifyColumnReg 修改列
+	dbDiffModifyColumnReg = regexp.MustCompile("(?is)^MODIFY\\s+(?:COLUMN\\s+)?`([\\w$]+)`\\s+(.*)$")
+	// dbDiffAddIndexReg 添加索引
+	dbDiffAddIndexReg = regexp.MustCompile("(?is)^ADD\\s+(?:CONSTRAINT\\s+(?:`[\\w$]+`\\s+)?)?(?:UNIQUE\\s+|FULLTEXT\\s+|SPATIAL\\s+)?(?:INDEX|KEY|PRIMARY\\s+KEY|FOREIGN\\s+KEY)\\s*(?:`([\\w$]+)`)?")
+	// dbDiffDropIndexReg 删除索引
+	dbDiffDropIndexReg = regexp.MustCompile("(?is)^DROP\\s+(?:INDEX|KEY|PRIMARY\\s+KEY|FOREIGN\\s+KEY)\\s*(?:`([\\w$]+)`)?")
+	// dbDiffTypeReg 列类型
+	dbDiffTypeReg = regexp.MustCompile(`(?is)^(\w+)(?:\s*\(([^)]*)\))?((?:\s+(?:UNSIGNED|ZEROFILL))*)`)
+)
+
+// DbParseDiff 将 DbStructGetDiff 的结果拆分为单条语句并分类, 忽略 BEGIN 和 COMMIT
+// 没有数据库信息时无法判断列类型是否缩小, 使用 DbClassifyDiff 获取完整分类
+func DbParseDiff(diffSQL string) []*DbDiffStatement {
+	var stmts []*DbDiffStatement
+	for _, s := range dbSplitStatements(diffSQL) {
+		upper := strings.ToUpper(s)
+		if upper == "BEGIN" || upper == "COMMIT" || upper == "START TRANSACTION" {
+			continue
+		}
+		stmts = append(stmts, dbDiffClassify(s))
+	}
+	return stmts
+}
+
+// DbClassifyDiff 拆分并分类更新语句, 修改列时读取数据库中的原类型判断是否缩小
+func DbClassifyDiff(ctx context.Context, tx DbExeAble, diffSQL string) ([]*DbDiffStatement, error) {
+	stmts := DbParseDiff(diffSQL)
+	for _, stmt := range stmts {
+		if stmt.Kind != DbDiffModifyColumn {
+			continue
+		}
+		oldType, ok, err := dbColumnType(ctx, tx, stmt.Table, stmt.Name)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		stmt.OldType = oldType
+		if dbDiffIsNarrowing(oldType, stmt.NewType) {
+			stmt.Kind = DbDiffNarrowColumn
+			stmt.Destructive = true
+		}
+	}
+	return stmts, nil
+}
+
+// DbStructApplyDiff 分析并逐条执行 DbStructGetDiff 的结果
+// 包含破坏性语句且不允许时不执行任何语句并返回 ErrDbDiffDestructive
+// mysql的DDL会隐式提交, 执行失败时之前的语句不会回滚
+func DbStructApplyDiff(ctx context.Context, tx DbExeAble, diffSQL string, opts *DbDiffApplyOptions) (*DbDiffApplyReport, error) {
+	if opts == nil {
+		opts = &DbDiffApplyOptions{}
+	}
+	stmts, err := DbClassifyDiff(ctx, tx, diffSQL)
+	if err != nil {
+		return nil, err
+	}
+	report := &DbDiffApplyReport{
+		Statements: stmts,
+		DryRun:     opts.DryRun,
+	}
+	for _, stmt := range stmts {
+		if stmt.Destructive && !opts.AllowDestructive {
+			report.Refused = append(report.Refused, stmt)
+		}
+	}
+	if opts.DryRun {
+		for i, stmt := range stmts {
+			Log.Infof("dry run diff %d/%d [%s]: %s", i+1, len(stmts), stmt.Kind, stmt.SQL)
+		}
+		return report, nil
+	}
+	if len(report.Refused) > 0 {
+		for _, stmt := range report.Refused {
+			Log.Warnf("refuse destructive diff [%s]: %s", stmt.Kind, stmt.SQL)
+		}
+		return report, ErrDbDiffDestructive
+	}
+	run := func(dbTx DbExeAble) error {
+		for i, stmt := range stmts {
+			start := time.Now()
+			_, err := dbExecRaw(ctx, dbTx, stmt.SQL)
+			if err != nil {
+				Log.Errorf("apply diff %d/%d [%s] error: %s %s", i+1, len(stmts), stmt.Kind, stmt.SQL, err.Error())
+				return err
+			}
+			report.Executed++
+			Log.Infof("apply diff %d/%d [%s] %s: %s", i+1, len(stmts), stmt.Kind, time.Since(start), stmt.SQL)
+		}
+		return nil
+	}
+	if dbIsTx(tx) {
+		return report, run(tx)
+	}
+	// 使用事物固定链接, 保证 SET FOREIGN_KEY_CHECKS 对后续语句生效
+	return report, DbTransaction(ctx, tx, run)
+}
+
+// dbIsTx 是否在事物中
+func dbIsTx(tx DbExeAble) bool {
+	for {
+		w, ok := tx.(dbWrapper)
+		if !ok {
+			break
+		}
+		tx = w.unwrapDb()
+	}
+	switch tx.(type) {
+	case *dbTx, *sqlx.Tx:
+		return true
+	}
+	return false
+}
+
+// dbDiffClassify 根据语句分类
+func dbDiffClassify(s string) *DbDiffStatement {
+	stmt := &DbDiffStatement{
+		SQL:  s,
+		Kind: DbDiffOther,
+	}
+	if m := dbDiffCreateTableReg.FindStringSubmatch(s); m != nil {
+		stmt.Kind = DbDiffCreateTable
+		stmt.Table = m[1]
+		return stmt
+	}
+	if m := dbDiffDropTableReg.FindStringSubmatch(s); m != nil {
+		stmt.Kind = DbDiffDropTable
+		stmt.Table = m[1]
+		stmt.Destructive = true
+		return stmt
+	}
+	m := dbDiffAlterTableReg.FindStringSubmatch(s)
+	if m == nil {
+		return stmt
+	}
+	stmt.Table = m[1]
+	spec := strings.TrimSpace(m[2])
+	if m := dbDiffAddIndexReg.FindStringSubmatch(spec); m != nil {
+		stmt.Kind = DbDiffAddIndex
+		stmt.Name = m[1]
+		return stmt
+	}
+	if m := dbDiffDropIndexReg.FindStringSubmatch(spec); m != nil {
+		stmt.Kind = DbDiffDropIndex
+		stmt.Name = m[1]
+		return stmt
+	}
+	if m := dbDiffAddColumnReg.FindStringSubmatch(spec); m != nil {
+		stmt.Kind = DbDiffAddColumn
+		stmt.Name = m[1]
+		return stmt
+	}
+	if m := dbDiffDropColumnReg.FindStringSubmatch(spec); m != nil {
+		stmt.Kind = DbDiffDropColumn
+		stmt.Name = m[1]
+		stmt.Destructive = true
+		return stmt
+	}
+	m = dbDiffChangeColumnReg.FindStringSubmatch(spec)
+	if m == nil {
+		m = dbDiffModifyColumnReg.FindStringSubmatch(spec)
+	}
+	if m != nil {
+		stmt.Kind = DbDiffModifyColumn
+		stmt.Name = m[1]
+		stmt.NewType = strings.TrimSpace(dbDiffTypeReg.FindString(m[2]))
+		return stmt
+	}
+	return stmt
+}
+
+// dbColumnType 获取数据库中列的类型, 列不存在时返回false
+func dbColumnType(ctx context.Context, tx DbExeAble, tableName string, columnName string) (string, bool, error) {
+	var row struct {
+		ColumnType string `db:"column_type"`
+	}
+	ok, err := DbGetNamedContent(
+		ctx,
+		tx,
+		&row,
+		`SELECT
+    column_type AS column_type
+FROM
+    information_schema.columns
+WHERE
+    table_schema=DATABASE()
+    AND table_name=:table_name
+    AND column_name=:column_name`,
+		gin.H{
+			"table_name":  tableName,
+			"column_name": columnName,
+		},
+	)
+	if err != nil {
+		return "", false, err
+	}
+	return row.ColumnType, ok, nil
+}
+
+// dbDiffIntRanks 整数类型大小
+var dbDiffIntRanks = map[string]int{
+	"TINYINT":   1,
+	"BOOL":      1,
+	"BOOLEAN":   1,
+	"SMALLINT":  2,
+	"MEDIUMINT": 3,
+	"INT":       4,
+	"INTEGER":   4,
+	"BIGINT":    5,
+}
+
+// dbDiffFloatRanks 浮点类型大小
+var dbDiffFloatRanks = map[string]int{
+	"FLOAT":  1,
+	"REAL":   2,
+	"DOUBLE": 2,
+}
+
+// dbDiffTextRanks 字符串类型大小, 有长度的类型按长度比较
+var dbDiffTextRanks = map[string]int{
+	"CHAR":       1,
+	"VARCHAR":    1,
+	"TINYTEXT":   2,
+	"TEXT":       3,
+	"MEDIUMTEXT": 4,
+	"LONGTEXT":   5,
+	"JSON":       5,
+}
+
+// dbDiffBlobRanks 二进制类型大小, 有长度的类型按长度比较
+var dbDiffBlobRanks = map[string]int{
+	"BINARY":     1,
+	"VARBINARY":  1,
+	"TINYBLOB":   2,
+	"BLOB":       3,
+	"MEDIUMBLOB": 4,
+	"LONGBLOB":   5,
+}
+
+// dbDiffType 解析后的列类型
+type dbDiffType struct {
+	name     string
+	args     []string
+	unsigned bool
+}
+
+// dbDiffParseType 解析列类型
+func dbDiffParseType(s string) (*dbDiffType, bool) {
+	m := dbDiffTypeReg.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return nil, false
+	}
+	t := &dbDiffType{
+		name:     strings.ToUpper(m[1]),
+		unsigned: strings.Contains(strings.ToUpper(m[3]), "UNSIGNED"),
+	}
+	if m[2] != "" {
+		for _, arg := range strings.Split(m[2], ",") {
+			t.args = append(t.args, strings.TrimSpace(arg))
+		}
+	}
+	return t, true
+}
+
+// dbDiffArgInt 获取类型的数字参数
+func dbDiffArgInt(t *dbDiffType, i int) (int64, bool) {
+	if i >= len(t.args) {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(t.args[i], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
+
+// dbDiffIsNarrowing 修改列类型是否可能丢失数据, 无法判断时视为缩小
+func dbDiffIsNarrowing(oldType string, newType string) bool {
+	o, ok := dbDiffParseType(oldType)
+	if !ok {
+		return true
+	}
+	n, ok := dbDiffParseType(newType)
+	if !ok {
+		return true
+	}
+	if oRank, ok := dbDiffIntRanks[o.name]; ok {
+		nRank, ok := dbDiffIntRanks[n.name]
+		if !ok {
+			// 整数转为其他类型视为缩小
+			return true
+		}
+		if nRank < oRank {
+			return true
+		}
+		if o.unsigned != n.unsigned {
+			// 无符号转有符号需要更大的类型, 有符号转无符号会丢失负数
+			return !o.unsigned || nRank == oRank
+		}
+		return false
+	}
+	if oRank, ok := dbDiffFloatRanks[o.name]; ok {
+		nRank, ok := dbDiffFloatRanks[n.name]
+		return !ok || nRank < oRank
+	}
+	if o.name == "DECIMAL" || o.name == "NUMERIC" {
+		if n.name != "DECIMAL" && n.name != "NUMERIC" {
+			return true
+		}
+		oPrecision, _ := dbDiffArgInt(o, 0)
+		oScale, _ := dbDiffArgInt(o, 1)
+		nPrecision, _ := dbDiffArgInt(n, 0)
+		nScale, _ := dbDiffArgInt(n, 1)
+		return nScale < oScale || nPrecision-nScale < oPrecision-oScale || (!o.unsigned && n.unsigned)
+	}
+	if narrow, ok := dbDiffIsNarrowingSized(dbDiffTextRanks, o, n); ok {
+		return narrow
+	}
+	if narrow, ok := dbDiffIsNarrowingSized(dbDiffBlobRanks, o, n); ok {
+		return narrow
+	}
+	if o.name == "ENUM" || o.name == "SET" {
+		if n.name != o.name {
+			return true
+		}
+		values := map[string]bool{}
+		for _, v := range n.args {
+			values[v] = true
+		}
+		for _, v := range o.args {
+			if !values[v] {
+				return true
+			}
+		}
+		return false
+	}
+	if o.name == n.name {
+		oLen, _ := dbDiffArgInt(o, 0)
+		nLen, _ := dbDiffArgInt(n, 0)
+		// 比较 DATETIME(6) 等的精度
+		return nLen < oLen
+	}
+	// DATETIME 和 TIMESTAMP 互转会改变范围, DATE 会丢失时间
+	return true
+}
+
+// dbDiffIsNarrowingSized 在同一类字符串或二进制类型中比较大小
+func dbDiffIsNarrowingSized(ranks map[string]int, o *dbDiffType, n *dbDiffType) (bool, bool) {
+	oRank, ok := ranks[o.name]
+	if !ok {
+		return false, false
+	}
+	nRank, ok := ranks[n.name]
+	if !ok {
+		return true, true
+	}
+	if oRank == 1 && nRank == 1 {
+		oLen, ok := dbDiffArgInt(o, 0)
+		if !ok {
+			oLen = 1
+		}
+		nLen, ok := dbDiffArgInt(n, 0)
+		if !ok {
+			nLen = 1
+		}
+		return nLen < oLen, true
+	}
+	return nRank < oRank, true
+}
@@ -0,0 +1,155 @@
+package mcommon
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDbParseDiff(t *testing.T) {
+	tests := []struct {
+		sql         string
+		kind        DbDiffKind
+		table       string
+		name        string
+		newType     string
+		destructive bool
+	}{
+		{"CREATE TABLE `t_a` (`id` int)", DbDiffCreateTable, "t_a", "", "", false},
+		{"DROP TABLE IF EXISTS `t_a`", DbDiffDropTable, "t_a", "", "", true},
+		{"ALTER TABLE `t_a` ADD COLUMN `c` int NOT NULL AFTER `id`", DbDiffAddColumn, "t_a", "c", "", false},
+		{"ALTER TABLE `t_a` DROP COLUMN `c`", DbDiffDropColumn, "t_a", "c", "", true},
+		{"ALTER TABLE `t_a` CHANGE COLUMN `c` `c` varchar(10) NOT NULL", DbDiffModifyColumn, "t_a", "c", "varchar(10)", false},
+		{"ALTER TABLE `t_a` MODIFY `c` int(11) unsigned NOT NULL", DbDiffModifyColumn, "t_a", "c", "int(11) unsigned", false},
+		{"ALTER TABLE `t_a` ADD UNIQUE INDEX `uk_c` (`c`)", DbDiffAddIndex, "t_a", "uk_c", "", false},
+		{"ALTER TABLE `t_a` ADD PRIMARY KEY (`id`)", DbDiffAddIndex, "t_a", "", "", false},
+		{"ALTER TABLE `t_a` DROP INDEX `uk_c`", DbDiffDropIndex, "t_a", "uk_c", "", false},
+		{"SET FOREIGN_KEY_CHECKS = 0", DbDiffOther, "", "", "", false},
+	}
+	for _, tt := range tests {
+		stmts := DbParseDiff("BEGIN;\n" + tt.sql + ";\nCOMMIT;")
+		if len(stmts) != 1 {
+			t.Fatalf("parse %q = %d stmts", tt.sql, len(stmts))
+		}
+		got := *stmts[0]
+		want := DbDiffStatement{
+			SQL:         tt.sql,
+			Kind:        tt.kind,
+			Table:       tt.table,
+			Name:        tt.name,
+			NewType:     tt.newType,
+			Destructive: tt.destructive,
+		}
+		if got != want {
+			t.Errorf("parse %q = %+v, want %+v", tt.sql, got, want)
+		}
+	}
+}
+
+func TestDbDiffIsNarrowing(t *testing.T) {
+	tests := []struct {
+		oldType string
+		newType string
+		want    bool
+	}{
+		{"int(11)", "bigint(20)", false},
+		{"bigint(20)", "int(11)", true},
+		{"int(11) unsigned", "bigint(20)", false},
+		{"int(11) unsigned", "int(11)", true},
+		{"int(11)", "int(11) unsigned", true},
+		{"int(11)", "varchar(20)", true},
+		{"float", "double", false},
+		{"double", "float", true},
+		{"decimal(10,2)", "decimal(12,2)", false},
+		{"decimal(10,2)", "decimal(10,4)", true},
+		{"decimal(10,2)", "decimal(10,1)", true},
+		{"varchar(32)", "varchar(64)", false},
+		{"varchar(64)", "varchar(32)", true},
+		{"varchar(64)", "text", false},
+		{"text", "varchar(64)", true},
+		{"varchar(64)", "blob", true},
+		{"varbinary(16)", "blob", false},
+		{"enum('a','b')", "enum('a','b','c')", false},
+		{"enum('a','b')", "enum('a')", true},
+		{"datetime", "datetime(6)", false},
+		{"datetime(6)", "datetime", true},
+		{"datetime", "date", true},
+		{"", "int", true},
+	}
+	for _, tt := range tests {
+		got := dbDiffIsNarrowing(tt.oldType, tt.newType)
+		if got != tt.want {
+			t.Errorf("narrowing %s -> %s = %v, want %v", tt.oldType, tt.newType, got, tt.want)
+		}
+	}
+}
+
+func TestDbStructApplyDiff(t *testing.T) {
+	diffSQL := "BEGIN;\n" +
+		"ALTER TABLE `t_a` ADD COLUMN `b` int NOT NULL;\n" +
+		"ALTER TABLE `t_a` MODIFY COLUMN `c` varchar(16) NOT NULL;\n" +
+		"COMMIT;"
+	tests := []struct {
+		name         string
+		oldType      string
+		opts         *DbDiffApplyOptions
+		wantErr      error
+		wantRefused  int
+		wantExecuted int
+	}{
+		{"widen", "varchar(8)", nil, nil, 0, 2},
+		{"narrow refused", "varchar(32)", nil, ErrDbDiffDestructive, 1, 0},
+		{"narrow allowed", "varchar(32)", &DbDiffApplyOptions{AllowDestructive: true}, nil, 0, 2},
+		{"dry run", "varchar(32)", &DbDiffApplyOptions{DryRun: true}, nil, 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := DbNewFake()
+			defer f.Close()
+			f.Expect(`information_schema\.columns`).WithArgs("t_a", "c").
+				WillReturnRows([]string{"column_type"}, []interface{}{tt.oldType})
+			f.Expect(`^ALTER TABLE`).WillReturnResult(0, 0)
+			report, err := DbStructApplyDiff(context.Background(), f.DB, diffSQL, tt.opts)
+			if err != tt.wantErr {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if len(report.Refused) != tt.wantRefused || report.Executed != tt.wantExecuted {
+				t.Fatalf("refused = %d, executed = %d", len(report.Refused), report.Executed)
+			}
+			var alters []string
+			for _, r := range f.Records() {
+				if strings.HasPrefix(r.Query, "ALTER") {
+					alters = append(alters, r.Query)
+				}
+			}
+			if len(alters) != tt.wantExecuted {
+				t.Fatalf("alters = %v", alters)
+			}
+			if report.Statements[1].OldType != tt.oldType {
+				t.Fatalf("old type = %s", report.Statements[1].OldType)
+			}
+		})
+	}
+}
+
+func TestDbStructApplyDiffInTx(t *testing.T) {
+	f := DbNewFake()
+	defer f.Close()
+	f.Expect(`^CREATE TABLE`).WillReturnResult(0, 0)
+	err := DbTransaction(context.Background(), f.DB, func(dbTx DbExeAble) error {
+		_, err := DbStructApplyDiff(context.Background(), dbTx, "CREATE TABLE `t_b` (`id` int);", nil)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range f.Records() {
+		got = append(got, r.Query)
+	}
+	want := []string{"BEGIN", "CREATE TABLE `t_b` (`id` int)", "COMMIT"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("queries = %q, want %q", got, want)
+	}
+}
@@ -3,16 +3,22 @@ package mcommon
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"math/rand"
 	"reflect"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
 
-	// 导入mysql
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
 )
 
 // DbExeAble 数据库接口
@@ -35,6 +41,12 @@ type DbExeAble interface {
 // isShowSQL 是否显示执行的sql语句
 var isShowSQL bool
 
+// sqlSlowThreshold 慢查询阈值, 0 为不记录慢查询
+var sqlSlowThreshold time.Duration
+
+// sqlPkgPrefix 本包函数名前缀, 用于查找调用位置
+const sqlPkgPrefix = "github.com/moremorefun/mcommon."
+
 // DbCreate 创建数据库链接
 func DbCreate(dataSourceName string, showSQL bool) *sqlx.DB {
 	isShowSQL = showSQL
@@ -66,27 +78,59 @@ func DbSetShowSQL(b bool) {
 	isShowSQL = b
 }
 
+// DbSetSlowThreshold 设置慢查询阈值, 超过阈值的sql即使不显示sql也会以warn级别记录
+func DbSetSlowThreshold(du time.Duration) {
+	sqlSlowThreshold = du
+}
+
 // DbExecuteCountManyContent 返回sql语句并返回执行行数
 func DbExecuteCountManyContent(ctx context.Context, tx DbExeAble, query string, n int, args ...interface{}) (int64, error) {
 	var err error
 	insertArgs := strings.Repeat("(?),", n)
 	insertArgs = strings.TrimSuffix(insertArgs, ",")
 	query = fmt.Sprintf(query, insertArgs)
+	args, err = dbTenantArgs(ctx, query, args)
+	if err != nil {
+		return 0, err
+	}
 	query, args, err = sqlx.In(query, args...)
 	if err != nil {
 		return 0, err
 	}
 	query = tx.Rebind(query)
-	sqlLog(query, args)
-	ret, err := tx.ExecContext(
-		ctx,
-		query,
-		args...,
-	)
+	var count int64
+	err = dbQueryRun(ctx, tx, query, args, func(ctx context.Context) (int64, error) {
+		ret, err := tx.ExecContext(
+			ctx,
+			query,
+			args...,
+		)
+		if err != nil {
+			return 0, err
+		}
+		count, err = ret.RowsAffected()
+		return count, err
+	})
 	if err != nil {
 		return 0, err
 	}
-	count, err := ret.RowsAffected()
+	return count, nil
+}
+
+// dbExecRaw 直接执行sql, 不解析命名参数, 用于执行DDL
+func dbExecRaw(ctx context.Context, tx DbExeAble, query string) (int64, error) {
+	var count int64
+	err := dbQueryRun(ctx, tx, query, nil, func(ctx context.Context) (int64, error) {
+		ret, err := tx.ExecContext(
+			ctx,
+			query,
+		)
+		if err != nil {
+			return 0, err
+		}
+		count, err = ret.RowsAffected()
+		return count, err
+	})
 	if err != nil {
 		return 0, err
 	}
@@ -95,25 +139,24 @@ func DbExecuteCountManyContent(ctx context.Context, tx DbExeAble, query string,
 
 // DbExecuteLastIDNamedContent 执行sql语句并返回lastID
 func DbExecuteLastIDNamedContent(ctx context.Context, tx DbExeAble, query string, argMap map[string]interface{}) (int64, error) {
-	query, args, err := sqlx.Named(query, argMap)
-	if err != nil {
-		return 0, err
-	}
-	query, args, err = sqlx.In(query, args...)
-	if err != nil {
-		return 0, err
-	}
-	query = tx.Rebind(query)
-	sqlLog(query, args)
-	ret, err := tx.ExecContext(
-		ctx,
-		query,
-		args...,
-	)
+	query, args, err := dbNamedQuery(ctx, tx, query, argMap)
 	if err != nil {
 		return 0, err
 	}
-	lastID, err := ret.LastInsertId()
+	var lastID int64
+	err = dbQueryRun(ctx, tx, query, args, func(ctx context.Context) (int64, error) {
+		ret, err := tx.ExecContext(
+			ctx,
+			query,
+			args...,
+		)
+		if err != nil {
+			return 0, err
+		}
+		count, _ := ret.RowsAffected()
+		lastID, err = ret.LastInsertId()
+		return count, err
+	})
 	if err != nil {
 		return 0, err
 	}
@@ -122,25 +165,23 @@ func DbExecuteLastIDNamedContent(ctx context.Context, tx DbExeAble, query string
 
 // DbExecuteCountNamedContent 执行sql语句返回执行个数
 func DbExecuteCountNamedContent(ctx context.Context, tx DbExeAble, query string, argMap map[string]interface{}) (int64, error) {
-	query, args, err := sqlx.Named(query, argMap)
-	if err != nil {
-		return 0, err
-	}
-	query, args, err = sqlx.In(query, args...)
-	if err != nil {
-		return 0, err
-	}
-	query = tx.Rebind(query)
-	sqlLog(query, args)
-	ret, err := tx.ExecContext(
-		ctx,
-		query,
-		args...,
-	)
+	query, args, err := dbNamedQuery(ctx, tx, query, argMap)
 	if err != nil {
 		return 0, err
 	}
-	count, err := ret.RowsAffected()
+	var count int64
+	err = dbQueryRun(ctx, tx, query, args, func(ctx context.Context) (int64, error) {
+		ret, err := tx.ExecContext(
+			ctx,
+			query,
+			args...,
+		)
+		if err != nil {
+			return 0, err
+		}
+		count, err = ret.RowsAffected()
+		return count, err
+	})
 	if err != nil {
 		return 0, err
 	}
@@ -149,22 +190,22 @@ func DbExecuteCountNamedContent(ctx context.Context, tx DbExeAble, query string,
 
 // DbGetNamedContent 执行sql查询并返回当个元素
 func DbGetNamedContent(ctx context.Context, tx DbExeAble, dest interface{}, query string, argMap map[string]interface{}) (bool, error) {
-	query, args, err := sqlx.Named(query, argMap)
+	query, args, err := dbNamedQuery(ctx, tx, query, argMap)
 	if err != nil {
 		return false, err
 	}
-	query, args, err = sqlx.In(query, args...)
-	if err != nil {
-		return false, err
-	}
-	query = tx.Rebind(query)
-	sqlLog(query, args)
-	err = tx.GetContext(
-		ctx,
-		dest,
-		query,
-		args...,
-	)
+	err = dbQueryRun(ctx, tx, query, args, func(ctx context.Context) (int64, error) {
+		err := tx.GetContext(
+			ctx,
+			dest,
+			query,
+			args...,
+		)
+		if err != nil {
+			return 0, err
+		}
+		return 1, nil
+	})
 	if err == sql.ErrNoRows {
 		// 没有元素
 		return false, nil
@@ -178,22 +219,19 @@ func DbGetNamedContent(ctx context.Context, tx DbExeAble, dest interface{}, quer
 
 // DbSelectNamedContent 执行sql查询并返回多行
 func DbSelectNamedContent(ctx context.Context, tx DbExeAble, dest interface{}, query string, argMap map[string]interface{}) error {
-	query, args, err := sqlx.Named(query, argMap)
+	query, args, err := dbNamedQuery(ctx, tx, query, argMap)
 	if err != nil {
 		return err
 	}
-	query, args, err = sqlx.In(query, args...)
-	if err != nil {
-		return err
-	}
-	query = tx.Rebind(query)
-	sqlLog(query, args)
-	err = tx.SelectContext(
-		ctx,
-		dest,
-		query,
-		args...,
-	)
+	err = dbQueryRun(ctx, tx, query, args, func(ctx context.Context) (int64, error) {
+		err := tx.SelectContext(
+			ctx,
+			dest,
+			query,
+			args...,
+		)
+		return sqlDestLen(dest), err
+	})
 	if err == sql.ErrNoRows {
 		// 没有元素
 		return nil
@@ -207,21 +245,26 @@ func DbSelectNamedContent(ctx context.Context, tx DbExeAble, dest interface{}, q
 
 // DbNamedRowsContent 执行sql查询并返回多行
 func DbNamedRowsContent(ctx context.Context, tx DbExeAble, query string, argMap map[string]interface{}) ([]gin.H, error) {
-	query, args, err := sqlx.Named(query, argMap)
-	if err != nil {
-		return nil, err
-	}
-	query, args, err = sqlx.In(query, args...)
+	query, args, err := dbNamedQuery(ctx, tx, query, argMap)
 	if err != nil {
 		return nil, err
 	}
-	query = tx.Rebind(query)
-	sqlLog(query, args)
-	rows, err := tx.QueryContext(
-		ctx,
-		query,
-		args...,
-	)
+	var mapRows []gin.H
+	err = dbQueryRun(ctx, tx, query, args, func(ctx context.Context) (int64, error) {
+		rows, err := tx.QueryContext(
+			ctx,
+			query,
+			args...,
+		)
+		if err != nil {
+			return 0, err
+		}
+		defer func() {
+			_ = rows.Close()
+		}()
+		mapRows, err = dbScanRowsMap(rows)
+		return int64(len(mapRows)), err
+	})
 	if err == sql.ErrNoRows {
 		// 没有元素
 		return nil, nil
@@ -229,40 +272,22 @@ func DbNamedRowsContent(ctx context.Context, tx DbExeAble, query string, argMap
 	if err != nil {
 		return nil, err
 	}
-	defer func() {
-		_ = rows.Close()
-	}()
+	return mapRows, nil
+}
+
+// dbScanRowsMap 将结果集转换为map
+// NULL 转换为 nil, DECIMAL 转换为 json.Number, 超出int64的无符号整数转换为 uint64
+// 未知类型优先使用 DbRegisterTypeConverter 注册的转换, 否则转换为字符串
+func dbScanRowsMap(rows *sql.Rows) ([]gin.H, error) {
 	cts, err := rows.ColumnTypes()
 	if err != nil {
 		return nil, err
 	}
 	l := len(cts)
-	columns := make([]reflect.Value, l)
 	columnsPoint := make([]interface{}, l)
+	columnsConvert := make([]func() (interface{}, error), l)
 	for i, ct := range cts {
-		dbType := ct.DatabaseTypeName()
-		goType, ok := MysqlTypeToGoMap[dbType]
-		if !ok {
-			return nil, fmt.Errorf("no db type: %s", dbType)
-		}
-		var tv reflect.Value
-		switch goType {
-		case MySqlGoTypeString:
-			tv = reflect.New(reflect.TypeOf(""))
-		case MySqlGoTypeInt64:
-			tv = reflect.New(reflect.TypeOf(int64(0)))
-		case MySqlGoTypeBytes:
-			tv = reflect.New(reflect.TypeOf([]byte{}))
-		case MySqlGoTypeFloat64:
-			tv = reflect.New(reflect.TypeOf(float64(0)))
-		case MySqlGoTypeTime:
-			tv = reflect.New(reflect.TypeOf(time.Time{}))
-		default:
-			return nil, fmt.Errorf("no go type: %d", goType)
-		}
-		e := tv.Elem()
-		columns[i] = e
-		columnsPoint[i] = e.Addr().Interface()
+		columnsPoint[i], columnsConvert[i] = dbNewScanColumn(ct)
 	}
 	var mapRows []gin.H
 	for rows.Next() {
@@ -271,17 +296,173 @@ func DbNamedRowsContent(ctx context.Context, tx DbExeAble, query string, argMap
 			return nil, err
 		}
 		rowMap := map[string]interface{}{}
-		for i, v := range columns {
-			colName := cts[i].Name()
-			rowMap[colName] = v.Interface()
+		for i, convert := range columnsConvert {
+			v, err := convert()
+			if err != nil {
+				return nil, fmt.Errorf("column %s convert err: %w", cts[i].Name(), err)
+			}
+			rowMap[cts[i].Name()] = v
 		}
 		mapRows = append(mapRows, rowMap)
 	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
 	return mapRows, nil
 }
 
+// dbNewScanColumn 根据列类型返回扫描目标和转换函数
+func dbNewScanColumn(ct *sql.ColumnType) (interface{}, func() (interface{}, error)) {
+	dbType := strings.ToUpper(ct.DatabaseTypeName())
+	if f, ok := dbGetTypeConverter(dbType); ok {
+		var v []byte
+		return &v, func() (interface{}, error) {
+			if v == nil {
+				return nil, nil
+			}
+			return f(v)
+		}
+	}
+	goType, ok := MysqlTypeToGoMap[dbType]
+	if !ok || dbType == "TIME" {
+		// TIME 为时长, 驱动返回字符串, 无法转换为 time.Time
+		goType = MySqlGoTypeString
+	}
+	scanType := ct.ScanType()
+	if dbType == "BIGINT" || (scanType != nil && scanType.Kind() == reflect.Uint64) {
+		// 无符号BIGINT可能超出int64, 可为空时驱动无法区分是否无符号, 按字符串读取后解析
+		goType = MySqlGoTypeUint64
+	}
+	switch goType {
+	case MySqlGoTypeInt64:
+		var v sql.NullInt64
+		return &v, func() (interface{}, error) {
+			if !v.Valid {
+				return nil, nil
+			}
+			return v.Int64, nil
+		}
+	case MySqlGoTypeUint64:
+		var v sql.NullString
+		return &v, func() (interface{}, error) {
+			if !v.Valid {
+				return nil, nil
+			}
+			i, err := strconv.ParseInt(v.String, 10, 64)
+			if err == nil {
+				return i, nil
+			}
+			return strconv.ParseUint(v.String, 10, 64)
+		}
+	case MySqlGoTypeBytes:
+		var v []byte
+		return &v, func() (interface{}, error) {
+			if v == nil {
+				return nil, nil
+			}
+			return v, nil
+		}
+	case MySqlGoTypeFloat64:
+		var v sql.NullFloat64
+		return &v, func() (interface{}, error) {
+			if !v.Valid {
+				return nil, nil
+			}
+			return v.Float64, nil
+		}
+	case MySqlGoTypeTime:
+		var v sql.NullTime
+		return &v, func() (interface{}, error) {
+			if !v.Valid {
+				return nil, nil
+			}
+			return v.Time, nil
+		}
+	default:
+		var v sql.NullString
+		return &v, func() (interface{}, error) {
+			if !v.Valid {
+				return nil, nil
+			}
+			return v.String, nil
+		}
+	}
+}
+
+// dbNamedQuery 转换命名参数和IN参数
+// 使用预处理语句缓存时复用命名参数的解析结果
+func dbNamedQuery(ctx context.Context, tx DbExeAble, query string, argMap map[string]interface{}) (string, []interface{}, error) {
+	argMap, err := dbTenantArgMap(ctx, query, argMap)
+	if err != nil {
+		return "", nil, err
+	}
+	cache := dbGetStmtCache(tx)
+	if cache != nil {
+		query, args, err := cache.namedQuery(query, argMap)
+		if err != nil {
+			return "", nil, err
+		}
+		return tx.Rebind(query), args, nil
+	}
+	query, args, err := sqlx.Named(query, argMap)
+	if err != nil {
+		return "", nil, err
+	}
+	query, args, err = sqlx.In(query, args...)
+	if err != nil {
+		return "", nil, err
+	}
+	return tx.Rebind(query), args, nil
+}
+
+// ErrStaleObject 乐观锁版本不一致, 数据已被其他操作修改, 需要重新读取后重试
+var ErrStaleObject = errors.New("stale object")
+
 // DbUpdateKV 更新
 func DbUpdateKV(ctx context.Context, tx DbExeAble, table string, updateMap H, keys []string, values []interface{}) (int64, error) {
+	return dbUpdateKV(ctx, tx, table, updateMap, nil, nil, keys, values)
+}
+
+// DbUpdateKVVersion 使用版本号乐观锁更新
+// 在更新内容中增加 versionKey=versionKey+1, 在条件中增加 versionKey=oldVersion, 没有更新行时返回 ErrStaleObject
+func DbUpdateKVVersion(ctx context.Context, tx DbExeAble, table string, updateMap H, keys []string, values []interface{}, versionKey string, oldVersion interface{}) (int64, error) {
+	if _, ok := updateMap[versionKey]; ok {
+		return 0, fmt.Errorf("update version key: %s", versionKey)
+	}
+	if len(keys) != len(values) {
+		return 0, fmt.Errorf("value len error")
+	}
+	for _, value := range values {
+		// IN 条件为空时不会更新任何行, 不是版本冲突
+		rv := reflect.ValueOf(value)
+		if rv.Kind() == reflect.Slice && rv.Len() == 0 {
+			return 0, nil
+		}
+	}
+	versionKeys := append(append([]string{}, keys...), versionKey)
+	versionValues := append(append([]interface{}{}, values...), oldVersion)
+	count, err := dbUpdateKV(
+		ctx,
+		tx,
+		table,
+		updateMap,
+		[]string{versionKey + "=" + versionKey + "+1"},
+		nil,
+		versionKeys,
+		versionValues,
+	)
+	if err != nil {
+		return 0, err
+	}
+	if count == 0 {
+		return 0, ErrStaleObject
+	}
+	return count, nil
+}
+
+// dbUpdateKV 更新, rawUpdates 和 rawWheres 为直接拼接的更新内容和条件
+func dbUpdateKV(ctx context.Context, tx DbExeAble, table string, updateMap H, rawUpdates []string, rawWheres []string, keys []string, values []interface{}) (int64, error) {
 	keysLen := len(keys)
 	if 0 == keysLen {
 		return 0, fmt.Errorf("keys len error")
@@ -289,7 +470,21 @@ func DbUpdateKV(ctx context.Context, tx DbExeAble, table string, updateMap H, ke
 	if keysLen != len(values) {
 		return 0, fmt.Errorf("value len error")
 	}
-	updateLastIndex := len(updateMap) - 1
+	keys, values = dbTenantKV(table, keys, values)
+	for k, v := range updateMap {
+		if !queryTenantIsColumn(table, k) {
+			continue
+		}
+		// 更新租户列时需要和上下文一致
+		newUpdateMap := H{}
+		for ok, ov := range updateMap {
+			newUpdateMap[ok] = ov
+		}
+		newUpdateMap[k] = queryTenantExplicit(v)
+		updateMap = newUpdateMap
+		break
+	}
+	updateLastIndex := len(updateMap) + len(rawUpdates) - 1
 
 	argMap := H{}
 	query := strings.Builder{}
@@ -312,6 +507,15 @@ func DbUpdateKV(ctx context.Context, tx DbExeAble, table string, updateMap H, ke
 		updateIndex++
 		argMap[argK] = v
 	}
+	for _, rawUpdate := range rawUpdates {
+		query.WriteString(rawUpdate)
+		if updateIndex == updateLastIndex {
+			query.WriteString("\n")
+		} else {
+			query.WriteString(",\n")
+		}
+		updateIndex++
+	}
 	query.WriteString("WHERE\n")
 	for i, key := range keys {
 		argK := strings.ReplaceAll(key, ".", "_")
@@ -339,6 +543,11 @@ func DbUpdateKV(ctx context.Context, tx DbExeAble, table string, updateMap H, ke
 		query.WriteString("\n")
 		argMap[argK] = value
 	}
+	for _, rawWhere := range rawWheres {
+		query.WriteString("AND ")
+		query.WriteString(rawWhere)
+		query.WriteString("\n")
+	}
 
 	count, err := DbExecuteCountNamedContent(
 		ctx,
@@ -352,8 +561,17 @@ func DbUpdateKV(ctx context.Context, tx DbExeAble, table string, updateMap H, ke
 	return count, nil
 }
 
-// DbDeleteKV 删除
+// DbDeleteKV 删除, 通过 QuerySetSoftDelete 设置软删除的表改为设置删除时间
 func DbDeleteKV(ctx context.Context, tx DbExeAble, table string, keys []string, values []interface{}) (int64, error) {
+	column, ok := querySoftDeleteColumn(table)
+	if ok {
+		return dbUpdateKV(ctx, tx, table, H{column: time.Now()}, nil, []string{column + " IS NULL"}, keys, values)
+	}
+	return DbHardDeleteKV(ctx, tx, table, keys, values)
+}
+
+// DbHardDeleteKV 物理删除, 忽略软删除设置
+func DbHardDeleteKV(ctx context.Context, tx DbExeAble, table string, keys []string, values []interface{}) (int64, error) {
 	keysLen := len(keys)
 	if 0 == keysLen {
 		return 0, fmt.Errorf("keys len error")
@@ -361,6 +579,7 @@ func DbDeleteKV(ctx context.Context, tx DbExeAble, table string, keys []string,
 	if keysLen != len(values) {
 		return 0, fmt.Errorf("value len error")
 	}
+	keys, values = dbTenantKV(table, keys, values)
 	argMap := H{}
 
 	query := strings.Builder{}
@@ -405,19 +624,44 @@ func DbDeleteKV(ctx context.Context, tx DbExeAble, table string, keys []string,
 	return count, nil
 }
 
+// savepointID 保存点序号
+var savepointID int64
+
 // DbTransaction 执行事物
-func DbTransaction(ctx context.Context, db *sqlx.DB, f func(dbTx DbExeAble) error) error {
+// db 为 *sqlx.DB 时开启新事物, 已在事物中时使用保存点, 出错时只回滚保存点之后的操作
+// f 中可以通过 DbOnCommit/DbOnRollback 注册提交或回滚后执行的函数
+func DbTransaction(ctx context.Context, db DbExeAble, f func(dbTx DbExeAble) error) error {
+	switch t := db.(type) {
+	case dbWrapper:
+		return DbTransaction(ctx, t.unwrapDb(), func(dbTx DbExeAble) error {
+			return f(t.rewrapDb(dbTx))
+		})
+	case *sqlx.DB:
+		return dbTransactionBegin(ctx, t, nil, f)
+	case *dbTx:
+		return dbTransactionSavepoint(ctx, t.Tx, t, f)
+	case *sqlx.Tx:
+		return dbTransactionSavepoint(ctx, t, nil, f)
+	default:
+		return fmt.Errorf("no transaction db type: %T", db)
+	}
+}
+
+// dbTransactionBegin 开启新事物
+func dbTransactionBegin(ctx context.Context, db *sqlx.DB, opts *sql.TxOptions, f func(dbTx DbExeAble) error) error {
 	isComment := false
-	tx, err := db.BeginTxx(ctx, nil)
+	tx, err := db.BeginTxx(ctx, opts)
 	if err != nil {
 		return err
 	}
+	hookTx := &dbTx{Tx: tx}
 	defer func() {
 		if !isComment {
 			_ = tx.Rollback()
+			hookTx.runRollbackHooks()
 		}
 	}()
-	err = f(tx)
+	err = f(hookTx)
 	if err != nil {
 		return err
 	}
@@ -426,20 +670,225 @@ func DbTransaction(ctx context.Context, db *sqlx.DB, f func(dbTx DbExeAble) erro
 		return err
 	}
 	isComment = true
+	hookTx.runCommitHooks()
+	return nil
+}
+
+// dbTransactionSavepoint 在已有事物中使用保存点
+// parent 不为空时, 保存点内注册的回调在释放后交给上层事物
+func dbTransactionSavepoint(ctx context.Context, tx *sqlx.Tx, parent *dbTx, f func(dbTx DbExeAble) error) error {
+	isRelease := false
+	var childTx DbExeAble = tx
+	var hookTx *dbTx
+	if parent != nil {
+		hookTx = &dbTx{Tx: tx, parent: parent}
+		childTx = hookTx
+	}
+	name := fmt.Sprintf("mcommon_sp_%d", atomic.AddInt64(&savepointID, 1))
+	_, err := tx.ExecContext(ctx, "SAVEPOINT "+name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if !isRelease {
+			_, _ = tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name)
+			if hookTx != nil {
+				hookTx.runRollbackHooks()
+			}
+		}
+	}()
+	err = f(childTx)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name)
+	if err != nil {
+		return err
+	}
+	isRelease = true
+	if hookTx != nil {
+		hookTx.mergeHooksToParent()
+	}
 	return nil
 }
 
-func sqlLog(query string, args []interface{}) {
-	if isShowSQL {
-		queryStr := query + ";"
-		for _, arg := range args {
-			_, ok := arg.(string)
-			if ok {
-				queryStr = strings.Replace(queryStr, "?", fmt.Sprintf(`"%s"`, arg), 1)
-			} else {
-				queryStr = strings.Replace(queryStr, "?", fmt.Sprintf(`%v`, arg), 1)
+// 可重试的mysql错误码
+const (
+	MysqlErrLockWaitTimeout = 1205
+	MysqlErrLockDeadlock    = 1213
+)
+
+// MysqlErrNoSuchTable 表不存在的mysql错误码
+const MysqlErrNoSuchTable = 1146
+
+// DbRetryOptions 重试事物配置
+type DbRetryOptions struct {
+	// Attempts 最多执行次数, 默认3次
+	Attempts int
+	// BaseDelay 首次重试等待时间, 之后翻倍, 默认20ms
+	BaseDelay time.Duration
+	// MaxDelay 最大等待时间, 默认1s
+	MaxDelay time.Duration
+	// TxOptions 隔离级别和只读设置
+	TxOptions sql.TxOptions
+	// ErrNumbers 可重试的mysql错误码, 默认死锁和锁等待超时
+	ErrNumbers []uint16
+}
+
+// DbIsRetryableErr 是否为可重试的mysql错误
+func DbIsRetryableErr(err error, errNumbers ...uint16) bool {
+	if len(errNumbers) == 0 {
+		errNumbers = []uint16{MysqlErrLockDeadlock, MysqlErrLockWaitTimeout}
+	}
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return false
+	}
+	for _, n := range errNumbers {
+		if mysqlErr.Number == n {
+			return true
+		}
+	}
+	return false
+}
+
+// DbTransactionRetry 执行事物, 遇到死锁等错误时整体重试
+// f 可能被执行多次, 不要在其中做不可重复的外部操作
+// db 已在事物中时不重试, 直接使用保存点执行, 由外层事物负责重试
+func DbTransactionRetry(ctx context.Context, db DbExeAble, opts *DbRetryOptions, f func(dbTx DbExeAble) error) error {
+	wrapDb, ok := db.(dbWrapper)
+	if ok {
+		return DbTransactionRetry(ctx, wrapDb.unwrapDb(), opts, func(dbTx DbExeAble) error {
+			return f(wrapDb.rewrapDb(dbTx))
+		})
+	}
+	sqlxDb, ok := db.(*sqlx.DB)
+	if !ok {
+		return DbTransaction(ctx, db, f)
+	}
+	var o DbRetryOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.Attempts <= 0 {
+		o.Attempts = 3
+	}
+	if o.BaseDelay <= 0 {
+		o.BaseDelay = 20 * time.Millisecond
+	}
+	if o.MaxDelay <= 0 {
+		o.MaxDelay = time.Second
+	}
+	delay := o.BaseDelay
+	var err error
+	for i := 0; i < o.Attempts; i++ {
+		if i > 0 {
+			// 等待 [delay/2, delay) 的随机时间
+			wait := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+			Log.Warnf("db transaction retry %d/%d after %s: %s", i+1, o.Attempts, wait, err.Error())
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+			delay *= 2
+			if delay > o.MaxDelay {
+				delay = o.MaxDelay
 			}
 		}
-		Log.Debugf("exec sql:\n%s;\n%#v", query, args)
+		err = dbTransactionBegin(ctx, sqlxDb, &o.TxOptions, f)
+		if err == nil {
+			return nil
+		}
+		if !DbIsRetryableErr(err, o.ErrNumbers...) {
+			return err
+		}
+	}
+	return err
+}
+
+// sqlLog 记录sql执行日志
+// 开启显示sql时以debug级别输出, 超过慢查询阈值时以warn级别输出
+func sqlLog(start time.Time, query string, args []interface{}, rows int64, err error) {
+	du := time.Since(start)
+	isSlow := sqlSlowThreshold > 0 && du >= sqlSlowThreshold
+	if !isShowSQL && !isSlow {
+		return
+	}
+	fields := []zap.Field{
+		zap.String("sql", sqlInterpolate(query, args)),
+		zap.Duration("duration", du),
+		zap.Int64("rows", rows),
+		zap.String("caller", sqlCaller()),
+	}
+	if err != nil {
+		fields = append(fields, zap.Error(err))
+	}
+	logger := ZapLog.WithOptions(zap.WithCaller(false))
+	if isSlow {
+		logger.Warn("slow sql", fields...)
+		return
+	}
+	logger.Debug("exec sql", fields...)
+}
+
+// sqlInterpolate 将参数填充到sql中, 仅用于日志显示
+func sqlInterpolate(query string, args []interface{}) string {
+	var buf strings.Builder
+	argIndex := 0
+	for _, c := range query {
+		if c != '?' || argIndex >= len(args) {
+			buf.WriteRune(c)
+			continue
+		}
+		sqlInterpolateValue(&buf, args[argIndex])
+		argIndex++
+	}
+	buf.WriteString(";")
+	return buf.String()
+}
+
+// sqlInterpolateValue 输出参数值
+func sqlInterpolateValue(buf *strings.Builder, arg interface{}) {
+	switch v := arg.(type) {
+	case nil:
+		buf.WriteString("NULL")
+	case string:
+		buf.WriteString(strconv.Quote(v))
+	case []byte:
+		buf.WriteString(strconv.Quote(string(v)))
+	case time.Time:
+		buf.WriteString(strconv.Quote(v.Format("2006-01-02 15:04:05.999999")))
+	case driver.Valuer:
+		dv, err := v.Value()
+		if err != nil {
+			buf.WriteString(fmt.Sprintf("%v", v))
+		} else {
+			sqlInterpolateValue(buf, dv)
+		}
+	default:
+		buf.WriteString(fmt.Sprintf("%v", v))
+	}
+}
+
+// sqlCaller 获取包外的调用位置
+func sqlCaller() string {
+	pcs := make([]uintptr, 16)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, sqlPkgPrefix) || !more {
+			return fmt.Sprintf("%s:%d", frame.File, frame.Line)
+		}
+	}
+}
+
+// sqlDestLen 获取Select结果行数
+func sqlDestLen(dest interface{}) int64 {
+	v := reflect.Indirect(reflect.ValueOf(dest))
+	if v.Kind() != reflect.Slice {
+		return 0
 	}
+	return int64(v.Len())
 }
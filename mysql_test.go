@@ -0,0 +1,254 @@
+package mcommon
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// dbTestSavepointReg 保存点名称
+var dbTestSavepointReg = regexp.MustCompile(`mcommon_sp_\d+`)
+
+// dbTestQueries 执行记录中的sql, 保存点名称替换为 sp
+func dbTestQueries(f *DbFake) []string {
+	var queries []string
+	for _, r := range f.Records() {
+		queries = append(queries, dbTestSavepointReg.ReplaceAllString(r.Query, "sp"))
+	}
+	return queries
+}
+
+func TestDbTransactionNested(t *testing.T) {
+	errInner := errors.New("inner")
+	tests := []struct {
+		name     string
+		innerErr error
+		outerErr error
+		want     []string
+	}{
+		{
+			name: "commit",
+			want: []string{"BEGIN", "INSERT a", "SAVEPOINT sp", "INSERT b", "RELEASE SAVEPOINT sp", "COMMIT"},
+		},
+		{
+			name:     "inner rollback",
+			innerErr: errInner,
+			want:     []string{"BEGIN", "INSERT a", "SAVEPOINT sp", "INSERT b", "ROLLBACK TO SAVEPOINT sp", "COMMIT"},
+		},
+		{
+			name:     "inner error returned",
+			innerErr: errInner,
+			outerErr: errInner,
+			want:     []string{"BEGIN", "INSERT a", "SAVEPOINT sp", "INSERT b", "ROLLBACK TO SAVEPOINT sp", "ROLLBACK"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := DbNewFake()
+			defer f.Close()
+			f.Expect(`^INSERT`)
+			err := DbTransaction(context.Background(), f.DB, func(tx DbExeAble) error {
+				_, err := tx.Exec("INSERT a")
+				if err != nil {
+					return err
+				}
+				err = DbTransaction(context.Background(), tx, func(tx DbExeAble) error {
+					_, err := tx.Exec("INSERT b")
+					if err != nil {
+						return err
+					}
+					return tt.innerErr
+				})
+				if tt.outerErr != nil {
+					return err
+				}
+				return nil
+			})
+			if err != tt.outerErr {
+				t.Fatalf("err = %v, want %v", err, tt.outerErr)
+			}
+			got := dbTestQueries(f)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("queries = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDbTransactionRetry(t *testing.T) {
+	errDeadlock := &mysql.MySQLError{Number: MysqlErrLockDeadlock, Message: "deadlock"}
+	errDup := &mysql.MySQLError{Number: 1062, Message: "duplicate"}
+	tests := []struct {
+		name      string
+		errs      []error
+		wantErr   error
+		wantCalls int
+	}{
+		{
+			name:      "success",
+			errs:      []error{nil},
+			wantCalls: 1,
+		},
+		{
+			name:      "retry deadlock",
+			errs:      []error{errDeadlock, errDeadlock, nil},
+			wantCalls: 3,
+		},
+		{
+			name:      "attempts exceeded",
+			errs:      []error{errDeadlock, errDeadlock, errDeadlock},
+			wantErr:   errDeadlock,
+			wantCalls: 3,
+		},
+		{
+			name:      "not retryable",
+			errs:      []error{errDup},
+			wantErr:   errDup,
+			wantCalls: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := DbNewFake()
+			defer f.Close()
+			for _, err := range tt.errs {
+				f.Expect(`^UPDATE`).Once().WillReturnError(err)
+			}
+			calls := 0
+			err := DbTransactionRetry(context.Background(), f.DB, &DbRetryOptions{BaseDelay: time.Millisecond}, func(tx DbExeAble) error {
+				calls++
+				_, err := tx.Exec("UPDATE t SET a=1")
+				return err
+			})
+			if err != tt.wantErr {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Fatalf("calls = %d, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestDbTransactionRetryInTx(t *testing.T) {
+	f := DbNewFake()
+	defer f.Close()
+	f.Expect(`^UPDATE`).WillReturnError(&mysql.MySQLError{Number: MysqlErrLockDeadlock})
+	calls := 0
+	_ = DbTransaction(context.Background(), f.DB, func(tx DbExeAble) error {
+		return DbTransactionRetry(context.Background(), tx, nil, func(tx DbExeAble) error {
+			calls++
+			_, err := tx.Exec("UPDATE t SET a=1")
+			return err
+		})
+	})
+	if calls != 1 {
+		t.Fatalf("calls = %d, want 1", calls)
+	}
+}
+
+func TestSqlInterpolate(t *testing.T) {
+	tests := []struct {
+		query string
+		args  []interface{}
+		want  string
+	}{
+		{"SELECT 1", nil, "SELECT 1;"},
+		{"SELECT ?, ?", []interface{}{1, "a"}, `SELECT 1, "a";`},
+		{"SELECT ?", []interface{}{nil}, "SELECT NULL;"},
+		{"SELECT ?", []interface{}{[]byte("b")}, `SELECT "b";`},
+		{"SELECT ?", []interface{}{sql.NullString{String: "c", Valid: true}}, `SELECT "c";`},
+		{"SELECT ?", []interface{}{sql.NullInt64{}}, "SELECT NULL;"},
+		{"SELECT ?, ?", []interface{}{1}, "SELECT 1, ?;"},
+	}
+	for _, tt := range tests {
+		got := sqlInterpolate(tt.query, tt.args)
+		if got != tt.want {
+			t.Errorf("sqlInterpolate(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestSqlLogSlow(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	oldLog, oldShow, oldSlow := ZapLog, isShowSQL, sqlSlowThreshold
+	defer func() {
+		ZapLog, isShowSQL, sqlSlowThreshold = oldLog, oldShow, oldSlow
+	}()
+	ZapLog = zap.New(core)
+	tests := []struct {
+		name      string
+		showSQL   bool
+		threshold time.Duration
+		wantMsg   string
+	}{
+		{"off", false, 0, ""},
+		{"show", true, 0, "exec sql"},
+		{"slow", false, time.Nanosecond, "slow sql"},
+		{"fast", false, time.Hour, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs.TakeAll()
+			isShowSQL = tt.showSQL
+			sqlSlowThreshold = tt.threshold
+			sqlLog(time.Now().Add(-time.Millisecond), "SELECT ?", []interface{}{1}, 1, nil)
+			entries := logs.TakeAll()
+			if tt.wantMsg == "" {
+				if len(entries) != 0 {
+					t.Fatalf("want no log, got %v", entries)
+				}
+				return
+			}
+			if len(entries) != 1 || entries[0].Message != tt.wantMsg {
+				t.Fatalf("logs = %v, want %s", entries, tt.wantMsg)
+			}
+			if entries[0].ContextMap()["sql"] != "SELECT 1;" {
+				t.Fatalf("sql field = %v", entries[0].ContextMap()["sql"])
+			}
+		})
+	}
+}
+
+func TestDbUpdateKVVersion(t *testing.T) {
+	tests := []struct {
+		name      string
+		updateMap H
+		values    []interface{}
+		affected  int64
+		wantErr   error
+		wantExec  bool
+		wantCount int64
+	}{
+		{"ok", H{"name": "a"}, []interface{}{1}, 1, nil, true, 1},
+		{"stale", H{"name": "a"}, []interface{}{1}, 0, ErrStaleObject, true, 0},
+		{"version in update", H{"version": 2}, []interface{}{1}, 1, errors.New("update version key: version"), false, 0},
+		{"empty in", H{"name": "a"}, []interface{}{[]int64{}}, 0, nil, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := DbNewFake()
+			defer f.Close()
+			f.Expect(`(?s)^UPDATE\nt_user\nSET\n.*version=version\+1\nWHERE\nid=\?\nAND version=\?`).WillReturnResult(0, tt.affected)
+			count, err := DbUpdateKVVersion(context.Background(), f.DB, "t_user", tt.updateMap, []string{"id"}, tt.values, "version", 3)
+			if fmt.Sprint(err) != fmt.Sprint(tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if count != tt.wantCount {
+				t.Fatalf("count = %d, want %d", count, tt.wantCount)
+			}
+			if (len(f.Records()) > 0) != tt.wantExec {
+				t.Fatalf("records = %v", f.Records())
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package mcommon
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// DbHookAble sql执行钩子
+type DbHookAble interface {
+	// BeforeQuery 执行前调用, 返回的ctx用于执行和后续钩子, 返回错误时不执行sql
+	BeforeQuery(ctx context.Context, query string, args []interface{}) (context.Context, error)
+	// AfterQuery 执行后调用, err 为执行结果, 查询无结果时为 sql.ErrNoRows
+	AfterQuery(ctx context.Context, query string, args []interface{}, du time.Duration, err error)
+}
+
+// dbGlobalHooks 全局钩子
+var dbGlobalHooks []DbHookAble
+
+// dbGlobalHooksLock 全局钩子锁
+var dbGlobalHooksLock sync.RWMutex
+
+// DbAddHook 注册全局钩子, 对所有Db执行函数生效
+func DbAddHook(hooks ...DbHookAble) {
+	dbGlobalHooksLock.Lock()
+	defer dbGlobalHooksLock.Unlock()
+	dbGlobalHooks = append(dbGlobalHooks, hooks...)
+}
+
+// DbClearHooks 清空全局钩子
+func DbClearHooks() {
+	dbGlobalHooksLock.Lock()
+	defer dbGlobalHooksLock.Unlock()
+	dbGlobalHooks = nil
+}
+
+// dbWrapper 包装其他数据库对象的对象, 开启事物时先对内层开启, 再包装事物对象
+type dbWrapper interface {
+	DbExeAble
+	unwrapDb() DbExeAble
+	rewrapDb(inner DbExeAble) DbExeAble
+}
+
+// dbHookDb 带钩子的数据库对象
+type dbHookDb struct {
+	DbExeAble
+
+	hooks []DbHookAble
+}
+
+func (t *dbHookDb) unwrapDb() DbExeAble {
+	return t.DbExeAble
+}
+
+func (t *dbHookDb) rewrapDb(inner DbExeAble) DbExeAble {
+	return &dbHookDb{DbExeAble: inner, hooks: t.hooks}
+}
+
+// DbWithHooks 返回带钩子的数据库对象, 钩子只对通过该对象执行的sql生效
+// 可以传给 DbTransaction, 事物中的sql同样执行钩子
+func DbWithHooks(tx DbExeAble, hooks ...DbHookAble) DbExeAble {
+	return &dbHookDb{DbExeAble: tx, hooks: hooks}
+}
+
+// dbGetHooks 获取sql需要执行的钩子, 先全局钩子, 再由外到内的对象钩子
+func dbGetHooks(tx DbExeAble) []DbHookAble {
+	dbGlobalHooksLock.RLock()
+	hooks := dbGlobalHooks
+	dbGlobalHooksLock.RUnlock()
+	var all []DbHookAble
+	all = append(all, hooks...)
+	for {
+		wrapDb, ok := tx.(dbWrapper)
+		if !ok {
+			return all
+		}
+		hookDb, ok := wrapDb.(*dbHookDb)
+		if ok {
+			all = append(all, hookDb.hooks...)
+		}
+		tx = wrapDb.unwrapDb()
+	}
+}
+
+// dbQueryRun 执行sql, 前后调用钩子并记录日志
+// run 返回影响或读取的行数
+func dbQueryRun(ctx context.Context, tx DbExeAble, query string, args []interface{}, run func(ctx context.Context) (int64, error)) error {
+	q, err := dbQueryBefore(ctx, tx, query, args)
+	if err != nil {
+		return err
+	}
+	rows, err := run(q.ctx)
+	q.after(rows, err)
+	return err
+}
+
+// dbQueryState 执行中的sql
+type dbQueryState struct {
+	ctx   context.Context
+	hooks []DbHookAble
+	query string
+	args  []interface{}
+	start time.Time
+}
+
+// dbQueryBefore 调用执行前钩子, 返回错误时已调用对应的执行后钩子
+func dbQueryBefore(ctx context.Context, tx DbExeAble, query string, args []interface{}) (*dbQueryState, error) {
+	q := &dbQueryState{
+		ctx:   ctx,
+		query: query,
+		args:  args,
+		start: time.Now(),
+	}
+	var err error
+	hooks := dbGetHooks(tx)
+	for _, hook := range hooks {
+		q.ctx, err = hook.BeforeQuery(q.ctx, query, args)
+		if err != nil {
+			q.after(0, err)
+			return nil, err
+		}
+		q.hooks = append(q.hooks, hook)
+	}
+	q.start = time.Now()
+	return q, nil
+}
+
+// after 调用执行后钩子并记录日志
+func (q *dbQueryState) after(rows int64, err error) {
+	du := time.Since(q.start)
+	for i := len(q.hooks) - 1; i >= 0; i-- {
+		q.hooks[i].AfterQuery(q.ctx, q.query, q.args, du, err)
+	}
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	sqlLog(q.start, q.query, q.args, rows, err)
+}
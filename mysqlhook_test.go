@@ -0,0 +1,123 @@
+package mcommon
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// dbTestHook 记录调用的钩子
+type dbTestHook struct {
+	name      string
+	calls     *[]string
+	beforeErr error
+	afterErrs []error
+}
+
+func (h *dbTestHook) BeforeQuery(ctx context.Context, query string, args []interface{}) (context.Context, error) {
+	*h.calls = append(*h.calls, h.name+" before "+query)
+	return ctx, h.beforeErr
+}
+
+func (h *dbTestHook) AfterQuery(ctx context.Context, query string, args []interface{}, du time.Duration, err error) {
+	*h.calls = append(*h.calls, h.name+" after "+query)
+	h.afterErrs = append(h.afterErrs, err)
+}
+
+func TestDbHooks(t *testing.T) {
+	errDeny := errors.New("deny")
+	tests := []struct {
+		name    string
+		run     func(ctx context.Context, tx DbExeAble) error
+		deny    bool
+		wantErr error
+		want    []string
+	}{
+		{
+			name: "exec",
+			run: func(ctx context.Context, tx DbExeAble) error {
+				_, err := DbExecuteCountNamedContent(ctx, tx, "UPDATE t SET a=1", nil)
+				return err
+			},
+			want: []string{
+				"global before UPDATE t SET a=1",
+				"local before UPDATE t SET a=1",
+				"local after UPDATE t SET a=1",
+				"global after UPDATE t SET a=1",
+			},
+		},
+		{
+			name: "transaction",
+			run: func(ctx context.Context, tx DbExeAble) error {
+				return DbTransaction(ctx, tx, func(tx DbExeAble) error {
+					_, err := DbExecuteCountNamedContent(ctx, tx, "UPDATE t SET a=1", nil)
+					return err
+				})
+			},
+			want: []string{
+				"global before UPDATE t SET a=1",
+				"local before UPDATE t SET a=1",
+				"local after UPDATE t SET a=1",
+				"global after UPDATE t SET a=1",
+			},
+		},
+		{
+			name: "deny",
+			run: func(ctx context.Context, tx DbExeAble) error {
+				_, err := DbExecuteCountNamedContent(ctx, tx, "UPDATE t SET a=1", nil)
+				return err
+			},
+			deny:    true,
+			wantErr: errDeny,
+			want: []string{
+				"global before UPDATE t SET a=1",
+				"local before UPDATE t SET a=1",
+				"global after UPDATE t SET a=1",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := DbNewFake()
+			defer f.Close()
+			f.Expect(`^UPDATE`).WillReturnResult(0, 1)
+			var calls []string
+			global := &dbTestHook{name: "global", calls: &calls}
+			local := &dbTestHook{name: "local", calls: &calls}
+			if tt.deny {
+				local.beforeErr = errDeny
+			}
+			DbAddHook(global)
+			defer DbClearHooks()
+			err := tt.run(context.Background(), DbWithHooks(f.DB, local))
+			if err != tt.wantErr {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(calls, tt.want) {
+				t.Fatalf("calls = %v, want %v", calls, tt.want)
+			}
+			if tt.deny && len(f.Records()) != 0 {
+				t.Fatalf("denied sql executed: %v", f.Records())
+			}
+		})
+	}
+}
+
+func TestDbHooksNoRows(t *testing.T) {
+	f := DbNewFake()
+	defer f.Close()
+	f.Expect(`^SELECT`).WillReturnRows([]string{"id"})
+	var calls []string
+	hook := &dbTestHook{name: "hook", calls: &calls}
+	var id int64
+	ok, err := DbGetNamedContent(context.Background(), DbWithHooks(f.DB, hook), &id, "SELECT id FROM t", nil)
+	if err != nil || ok {
+		t.Fatalf("ok = %v, err = %v", ok, err)
+	}
+	if len(hook.afterErrs) != 1 || hook.afterErrs[0] != sql.ErrNoRows {
+		t.Fatalf("after errs = %v, want sql.ErrNoRows", hook.afterErrs)
+	}
+}
@@ -0,0 +1,166 @@
+package mcommon
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+)
+
+// DbRows 流式读取的结果集
+type DbRows struct {
+	ctx      context.Context
+	rows     *sqlx.Rows
+	q        *dbQueryState
+	count    int64
+	err      error
+	isClosed bool
+
+	cts            []*sql.ColumnType
+	columnsPoint   []interface{}
+	columnsConvert []func() (interface{}, error)
+}
+
+// DbNamedRowsIterContent 执行sql查询并返回流式结果集, 使用完后需要调用 Close
+func DbNamedRowsIterContent(ctx context.Context, tx DbExeAble, query string, argMap map[string]interface{}) (*DbRows, error) {
+	query, args, err := dbNamedQuery(ctx, tx, query, argMap)
+	if err != nil {
+		return nil, err
+	}
+	q, err := dbQueryBefore(ctx, tx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := tx.QueryxContext(
+		q.ctx,
+		query,
+		args...,
+	)
+	if err != nil {
+		q.after(0, err)
+		return nil, err
+	}
+	return &DbRows{
+		ctx:  q.ctx,
+		rows: rows,
+		q:    q,
+	}, nil
+}
+
+// DbNamedEachContent 执行sql查询并逐行回调, 回调返回错误时停止读取
+func DbNamedEachContent(ctx context.Context, tx DbExeAble, query string, argMap map[string]interface{}, f func(rows *DbRows) error) error {
+	rows, err := DbNamedRowsIterContent(ctx, tx, query, argMap)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	for rows.Next() {
+		err = f(rows)
+		if err != nil {
+			rows.err = err
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Next 读取下一行, 没有数据, 出错或ctx取消时返回false
+func (r *DbRows) Next() bool {
+	if r.isClosed || r.err != nil {
+		return false
+	}
+	err := r.ctx.Err()
+	if err != nil {
+		r.err = err
+		return false
+	}
+	if !r.rows.Next() {
+		return false
+	}
+	r.count++
+	return true
+}
+
+// Scan 读取当前行
+// dest 为 *gin.H, *H 或 *map[string]interface{} 时按列名填充
+// 为 sql.Scanner 或 *time.Time 时读取单列, 为其他结构体指针时按db标签填充, 否则按列顺序填充
+func (r *DbRows) Scan(dest ...interface{}) error {
+	if len(dest) != 1 {
+		return r.rows.Scan(dest...)
+	}
+	var err error
+	switch d := dest[0].(type) {
+	case *gin.H:
+		*d, err = r.scanMap()
+	case *H:
+		*d, err = r.scanMap()
+	case *map[string]interface{}:
+		*d, err = r.scanMap()
+	case sql.Scanner, *time.Time:
+		// 单列读取到 sql.NullString, time.Time 等结构体
+		err = r.rows.Scan(d)
+	default:
+		rv := reflect.ValueOf(d)
+		if rv.Kind() == reflect.Ptr && reflect.Indirect(rv).Kind() == reflect.Struct {
+			err = r.rows.StructScan(d)
+		} else {
+			err = r.rows.Scan(d)
+		}
+	}
+	return err
+}
+
+// scanMap 读取当前行为map
+func (r *DbRows) scanMap() (map[string]interface{}, error) {
+	if r.cts == nil {
+		cts, err := r.rows.ColumnTypes()
+		if err != nil {
+			return nil, err
+		}
+		l := len(cts)
+		r.columnsPoint = make([]interface{}, l)
+		r.columnsConvert = make([]func() (interface{}, error), l)
+		for i, ct := range cts {
+			r.columnsPoint[i], r.columnsConvert[i] = dbNewScanColumn(ct)
+		}
+		r.cts = cts
+	}
+	err := r.rows.Scan(r.columnsPoint...)
+	if err != nil {
+		return nil, err
+	}
+	rowMap := map[string]interface{}{}
+	for i, convert := range r.columnsConvert {
+		v, err := convert()
+		if err != nil {
+			return nil, fmt.Errorf("column %s convert err: %w", r.cts[i].Name(), err)
+		}
+		rowMap[r.cts[i].Name()] = v
+	}
+	return rowMap, nil
+}
+
+// Err 返回读取过程中的错误
+func (r *DbRows) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.rows.Err()
+}
+
+// Close 关闭结果集, 可重复调用
+func (r *DbRows) Close() error {
+	if r.isClosed {
+		return nil
+	}
+	r.isClosed = true
+	err := r.rows.Close()
+	r.q.after(r.count, r.Err())
+	return err
+}
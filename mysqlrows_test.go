@@ -0,0 +1,141 @@
+package mcommon
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDbRowsScan(t *testing.T) {
+	at := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	type row struct {
+		ID   int64  `db:"id"`
+		Name string `db:"name"`
+	}
+	tests := []struct {
+		name    string
+		columns []string
+		rows    [][]interface{}
+		newDest func() interface{}
+		want    []interface{}
+	}{
+		{
+			name:    "map",
+			columns: []string{"id", "name"},
+			rows:    [][]interface{}{{1, "a"}, {2, nil}},
+			newDest: func() interface{} { return &H{} },
+			want:    []interface{}{&H{"id": int64(1), "name": "a"}, &H{"id": int64(2), "name": nil}},
+		},
+		{
+			name:    "struct",
+			columns: []string{"id", "name"},
+			rows:    [][]interface{}{{1, "a"}},
+			newDest: func() interface{} { return &row{} },
+			want:    []interface{}{&row{ID: 1, Name: "a"}},
+		},
+		{
+			name:    "int",
+			columns: []string{"id"},
+			rows:    [][]interface{}{{1}},
+			newDest: func() interface{} { return new(int64) },
+			want:    []interface{}{func() *int64 { v := int64(1); return &v }()},
+		},
+		{
+			name:    "time",
+			columns: []string{"at"},
+			rows:    [][]interface{}{{at}},
+			newDest: func() interface{} { return &time.Time{} },
+			want:    []interface{}{&at},
+		},
+		{
+			name:    "null string",
+			columns: []string{"name"},
+			rows:    [][]interface{}{{"a"}, {nil}},
+			newDest: func() interface{} { return &sql.NullString{} },
+			want:    []interface{}{&sql.NullString{String: "a", Valid: true}, &sql.NullString{}},
+		},
+		{
+			name:    "null int",
+			columns: []string{"id"},
+			rows:    [][]interface{}{{nil}},
+			newDest: func() interface{} { return &sql.NullInt64{} },
+			want:    []interface{}{&sql.NullInt64{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := DbNewFake()
+			defer f.Close()
+			f.Expect(`^SELECT`).WillReturnRows(tt.columns, tt.rows...)
+			var got []interface{}
+			err := DbNamedEachContent(context.Background(), f.DB, "SELECT * FROM t", nil, func(rows *DbRows) error {
+				dest := tt.newDest()
+				err := rows.Scan(dest)
+				if err != nil {
+					return err
+				}
+				got = append(got, dest)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDbRowsScanTime(t *testing.T) {
+	f := DbNewFake()
+	defer f.Close()
+	f.Expect(`^SELECT`).WithColumnTypes("TIME", "TIME").WillReturnRows(
+		[]string{"duration", "empty"},
+		[]interface{}{[]byte("-12:30:05"), nil},
+	)
+	want := []gin.H{{"duration": "-12:30:05", "empty": nil}}
+	rows, err := DbNamedRowsContent(context.Background(), f.DB, "SELECT * FROM t", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Fatalf("rows = %v, want %v", rows, want)
+	}
+	var got []interface{}
+	err = DbNamedEachContent(context.Background(), f.DB, "SELECT * FROM t", nil, func(rows *DbRows) error {
+		row := H{}
+		err := rows.Scan(&row)
+		got = append(got, row)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, []interface{}{H(want[0])}) {
+		t.Fatalf("each = %v, want %v", got, want)
+	}
+}
+
+func TestDbNamedEachContentStop(t *testing.T) {
+	f := DbNewFake()
+	defer f.Close()
+	f.Expect(`^SELECT`).WillReturnRows([]string{"id"}, []interface{}{1}, []interface{}{2}, []interface{}{3})
+	errStop := errors.New("stop")
+	n := 0
+	err := DbNamedEachContent(context.Background(), f.DB, "SELECT id FROM t", nil, func(rows *DbRows) error {
+		n++
+		if n == 2 {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop || n != 2 {
+		t.Fatalf("err = %v, n = %d", err, n)
+	}
+}
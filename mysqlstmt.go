@@ -0,0 +1,438 @@
+package mcommon
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+)
+
+// dbLru 最近最少使用缓存, 非并发安全
+type dbLru struct {
+	size    int
+	ll      *list.List
+	items   map[string]*list.Element
+	onEvict func(value interface{})
+}
+
+// dbLruEntry 缓存项
+type dbLruEntry struct {
+	key   string
+	value interface{}
+}
+
+// newDbLru 创建缓存
+func newDbLru(size int, onEvict func(value interface{})) *dbLru {
+	return &dbLru{
+		size:    size,
+		ll:      list.New(),
+		items:   map[string]*list.Element{},
+		onEvict: onEvict,
+	}
+}
+
+// get 获取
+func (c *dbLru) get(key string) (interface{}, bool) {
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	return e.Value.(*dbLruEntry).value, true
+}
+
+// add 添加, 超出容量时淘汰最久未使用的项
+func (c *dbLru) add(key string, value interface{}) {
+	e, ok := c.items[key]
+	if ok {
+		c.ll.MoveToFront(e)
+		e.Value.(*dbLruEntry).value = value
+		return
+	}
+	c.items[key] = c.ll.PushFront(&dbLruEntry{key: key, value: value})
+	for c.ll.Len() > c.size {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+// remove 删除
+func (c *dbLru) remove(key string) {
+	e, ok := c.items[key]
+	if ok {
+		c.removeElement(e)
+	}
+}
+
+// clear 清空
+func (c *dbLru) clear() {
+	for c.ll.Len() > 0 {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+func (c *dbLru) removeElement(e *list.Element) {
+	c.ll.Remove(e)
+	entry := e.Value.(*dbLruEntry)
+	delete(c.items, entry.key)
+	if c.onEvict != nil {
+		c.onEvict(entry.value)
+	}
+}
+
+// dbNamedCompiled 解析后的命名参数sql
+type dbNamedCompiled struct {
+	query string
+	names []string
+}
+
+// DbStmtCache 命名参数解析和预处理语句缓存
+// 通过 DbWithStmtCache 包装数据库对象后使用, 事物中使用同一数据库的预处理语句
+type DbStmtCache struct {
+	db       *sqlx.DB
+	mu       sync.Mutex
+	named    *dbLru
+	stmts    *dbLru
+	isClosed bool
+}
+
+// DbNewStmtCache 创建缓存, size 为命名参数解析和预处理语句各自的最大缓存数, 默认256
+func DbNewStmtCache(db *sqlx.DB, size int) *DbStmtCache {
+	if size <= 0 {
+		size = 256
+	}
+	return &DbStmtCache{
+		db:    db,
+		named: newDbLru(size, nil),
+		stmts: newDbLru(size, func(value interface{}) {
+			_ = value.(*sqlx.Stmt).Close()
+		}),
+	}
+}
+
+// Close 关闭所有预处理语句, 关闭数据库链接前调用, 关闭后直接执行sql
+func (c *DbStmtCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.isClosed = true
+	c.named.clear()
+	c.stmts.clear()
+	return nil
+}
+
+// Clear 清空缓存
+func (c *DbStmtCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.named.clear()
+	c.stmts.clear()
+}
+
+// namedQuery 使用缓存的解析结果转换命名参数和IN参数
+func (c *DbStmtCache) namedQuery(query string, argMap map[string]interface{}) (string, []interface{}, error) {
+	c.mu.Lock()
+	v, ok := c.named.get(query)
+	c.mu.Unlock()
+	var compiled *dbNamedCompiled
+	if ok {
+		compiled = v.(*dbNamedCompiled)
+	} else {
+		// 以参数名作为参数值解析, 得到按顺序排列的参数名
+		probe := make(map[string]interface{}, len(argMap))
+		for k := range argMap {
+			probe[k] = k
+		}
+		q, names, err := sqlx.Named(query, probe)
+		if err != nil {
+			return "", nil, err
+		}
+		compiled = &dbNamedCompiled{
+			query: q,
+			names: make([]string, len(names)),
+		}
+		for i, name := range names {
+			compiled.names[i] = name.(string)
+		}
+		c.mu.Lock()
+		c.named.add(query, compiled)
+		c.mu.Unlock()
+	}
+	args := make([]interface{}, len(compiled.names))
+	for i, name := range compiled.names {
+		arg, ok := argMap[name]
+		if !ok {
+			return "", nil, fmt.Errorf("could not find name %s in argMap", name)
+		}
+		args[i] = arg
+	}
+	return sqlx.In(compiled.query, args...)
+}
+
+// stmt 获取预处理语句, 不存在时预处理并缓存, isCached 为是否使用了已缓存的语句
+func (c *DbStmtCache) stmt(ctx context.Context, query string) (stmt *sqlx.Stmt, isCached bool, err error) {
+	c.mu.Lock()
+	if c.isClosed {
+		c.mu.Unlock()
+		return nil, false, errDbStmtCacheClosed
+	}
+	v, ok := c.stmts.get(query)
+	c.mu.Unlock()
+	if ok {
+		return v.(*sqlx.Stmt), true, nil
+	}
+	stmt, err = c.db.PreparexContext(ctx, query)
+	if err != nil {
+		return nil, false, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.isClosed {
+		_ = stmt.Close()
+		return nil, false, errDbStmtCacheClosed
+	}
+	v, ok = c.stmts.get(query)
+	if ok {
+		// 其他协程已经预处理
+		_ = stmt.Close()
+		return v.(*sqlx.Stmt), true, nil
+	}
+	c.stmts.add(query, stmt)
+	return stmt, false, nil
+}
+
+// invalidate 已缓存的语句执行出错时删除缓存, 返回是否需要重新预处理
+// mysql返回的错误, 无结果和ctx取消说明语句本身可用, 其他错误如语句已关闭, 链接失效时重新预处理
+func (c *DbStmtCache) invalidate(query string, err error) bool {
+	var mysqlErr *mysql.MySQLError
+	switch {
+	case errors.As(err, &mysqlErr),
+		errors.Is(err, sql.ErrNoRows),
+		errors.Is(err, context.Canceled),
+		errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, mysql.ErrInvalidConn):
+		// ErrInvalidConn 时sql可能已经执行, 不重试
+		return false
+	}
+	c.mu.Lock()
+	c.stmts.remove(query)
+	c.mu.Unlock()
+	return true
+}
+
+// errDbStmtCacheClosed 缓存已关闭
+var errDbStmtCacheClosed = errors.New("stmt cache closed")
+
+// dbStmtDb 使用预处理语句缓存的数据库对象
+type dbStmtDb struct {
+	DbExeAble
+
+	cache *DbStmtCache
+}
+
+func (t *dbStmtDb) unwrapDb() DbExeAble {
+	return t.DbExeAble
+}
+
+func (t *dbStmtDb) rewrapDb(inner DbExeAble) DbExeAble {
+	return &dbStmtDb{DbExeAble: inner, cache: t.cache}
+}
+
+// DbWithStmtCache 返回使用预处理语句缓存的数据库对象
+// tx 需要是创建缓存的 *sqlx.DB 或其开启的事物, 可以传给 DbTransaction
+func DbWithStmtCache(tx DbExeAble, cache *DbStmtCache) DbExeAble {
+	return &dbStmtDb{DbExeAble: tx, cache: cache}
+}
+
+// dbGetStmtCache 获取数据库对象使用的缓存
+func dbGetStmtCache(tx DbExeAble) *DbStmtCache {
+	for {
+		switch t := tx.(type) {
+		case *dbStmtDb:
+			return t.cache
+		case dbWrapper:
+			tx = t.unwrapDb()
+		default:
+			return nil
+		}
+	}
+}
+
+// runStmt 使用预处理语句执行, 语句失效时重新预处理一次
+// 内层不是数据库或事物, 缓存已关闭或语句不支持预处理时直接执行
+func (t *dbStmtDb) runStmt(ctx context.Context, query string, f func(stmt *sqlx.Stmt) error, direct func() error) error {
+	if !dbStmtIsPreparable(query) {
+		return direct()
+	}
+	tx, ok := dbStmtTarget(t.DbExeAble)
+	if !ok {
+		return direct()
+	}
+	for i := 0; ; i++ {
+		stmt, isCached, err := t.cache.stmt(ctx, query)
+		if err == errDbStmtCacheClosed {
+			return direct()
+		}
+		if err != nil {
+			return err
+		}
+		if tx != nil {
+			// 事物中的语句在事物结束时自动关闭
+			stmt = tx.StmtxContext(ctx, stmt)
+		}
+		err = f(stmt)
+		if err != nil && i == 0 && isCached && t.cache.invalidate(query, err) {
+			continue
+		}
+		return err
+	}
+}
+
+// dbStmtIsPreparable 是否使用预处理语句, 只缓存增删改查, DDL等语句不支持预处理
+func dbStmtIsPreparable(query string) bool {
+	query = strings.TrimLeft(query, " \t\r\n(")
+	i := strings.IndexFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	if i >= 0 {
+		query = query[:i]
+	}
+	switch strings.ToUpper(query) {
+	case "SELECT", "INSERT", "UPDATE", "DELETE", "REPLACE":
+		return true
+	}
+	return false
+}
+
+// dbStmtTarget 获取内层的事物, 内层不是数据库或事物时返回false
+func dbStmtTarget(tx DbExeAble) (*sqlx.Tx, bool) {
+	for {
+		switch t := tx.(type) {
+		case *sqlx.DB:
+			return nil, true
+		case *sqlx.Tx:
+			return t, true
+		case *dbTx:
+			return t.Tx, true
+		case dbWrapper:
+			tx = t.unwrapDb()
+		default:
+			return nil, false
+		}
+	}
+}
+
+// Get 使用预处理语句查询单行
+func (t *dbStmtDb) Get(dest interface{}, query string, args ...interface{}) error {
+	return t.GetContext(context.Background(), dest, query, args...)
+}
+
+// Exec 使用预处理语句执行
+func (t *dbStmtDb) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return t.ExecContext(context.Background(), query, args...)
+}
+
+// Select 使用预处理语句查询多行
+func (t *dbStmtDb) Select(dest interface{}, query string, args ...interface{}) error {
+	return t.SelectContext(context.Background(), dest, query, args...)
+}
+
+// GetContext 使用预处理语句查询单行
+func (t *dbStmtDb) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return t.runStmt(ctx, query, func(stmt *sqlx.Stmt) error {
+		return stmt.GetContext(ctx, dest, args...)
+	}, func() error {
+		return t.DbExeAble.GetContext(ctx, dest, query, args...)
+	})
+}
+
+// ExecContext 使用预处理语句执行
+func (t *dbStmtDb) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	var ret sql.Result
+	err := t.runStmt(ctx, query, func(stmt *sqlx.Stmt) error {
+		var err error
+		ret, err = stmt.ExecContext(ctx, args...)
+		return err
+	}, func() error {
+		var err error
+		ret, err = t.DbExeAble.ExecContext(ctx, query, args...)
+		return err
+	})
+	return ret, err
+}
+
+// SelectContext 使用预处理语句查询多行
+func (t *dbStmtDb) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return t.runStmt(ctx, query, func(stmt *sqlx.Stmt) error {
+		return stmt.SelectContext(ctx, dest, args...)
+	}, func() error {
+		return t.DbExeAble.SelectContext(ctx, dest, query, args...)
+	})
+}
+
+// QueryContext 使用预处理语句查询
+func (t *dbStmtDb) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	var rows *sql.Rows
+	err := t.runStmt(ctx, query, func(stmt *sqlx.Stmt) error {
+		var err error
+		rows, err = stmt.QueryContext(ctx, args...)
+		return err
+	}, func() error {
+		var err error
+		rows, err = t.DbExeAble.QueryContext(ctx, query, args...)
+		return err
+	})
+	return rows, err
+}
+
+// QueryxContext 使用预处理语句查询
+func (t *dbStmtDb) QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error) {
+	var rows *sqlx.Rows
+	err := t.runStmt(ctx, query, func(stmt *sqlx.Stmt) error {
+		var err error
+		rows, err = stmt.QueryxContext(ctx, args...)
+		return err
+	}, func() error {
+		var err error
+		rows, err = t.DbExeAble.QueryxContext(ctx, query, args...)
+		return err
+	})
+	return rows, err
+}
+
+// QueryRowContext 使用预处理语句查询单行, 预处理失败时直接执行
+func (t *dbStmtDb) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	var row *sql.Row
+	err := t.runStmt(ctx, query, func(stmt *sqlx.Stmt) error {
+		row = stmt.QueryRowContext(ctx, args...)
+		return nil
+	}, func() error {
+		row = t.DbExeAble.QueryRowContext(ctx, query, args...)
+		return nil
+	})
+	if err != nil {
+		return t.DbExeAble.QueryRowContext(ctx, query, args...)
+	}
+	return row
+}
+
+// QueryRowxContext 使用预处理语句查询单行, 预处理失败时直接执行
+func (t *dbStmtDb) QueryRowxContext(ctx context.Context, query string, args ...interface{}) *sqlx.Row {
+	var row *sqlx.Row
+	err := t.runStmt(ctx, query, func(stmt *sqlx.Stmt) error {
+		row = stmt.QueryRowxContext(ctx, args...)
+		return nil
+	}, func() error {
+		row = t.DbExeAble.QueryRowxContext(ctx, query, args...)
+		return nil
+	})
+	if err != nil {
+		return t.DbExeAble.QueryRowxContext(ctx, query, args...)
+	}
+	return row
+}
@@ -0,0 +1,175 @@
+package mcommon
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+)
+
+func TestDbLru(t *testing.T) {
+	var evicted []interface{}
+	c := newDbLru(2, func(value interface{}) {
+		evicted = append(evicted, value)
+	})
+	c.add("a", 1)
+	c.add("b", 2)
+	c.get("a")
+	c.add("c", 3)
+	if _, ok := c.get("b"); ok {
+		t.Fatalf("b not evicted")
+	}
+	if !reflect.DeepEqual(evicted, []interface{}{2}) {
+		t.Fatalf("evicted = %v, want [2]", evicted)
+	}
+	c.remove("a")
+	c.clear()
+	if !reflect.DeepEqual(evicted, []interface{}{2, 1, 3}) {
+		t.Fatalf("evicted = %v, want [2 1 3]", evicted)
+	}
+}
+
+func TestDbStmtCacheNamedQuery(t *testing.T) {
+	f := DbNewFake()
+	defer f.Close()
+	c := DbNewStmtCache(f.DB, 0)
+	defer c.Close()
+	tests := []struct {
+		argMap    map[string]interface{}
+		wantQuery string
+		wantArgs  []interface{}
+	}{
+		{H{"id": 1, "name": "a"}, "SELECT * FROM t WHERE id IN (?) AND name=?", []interface{}{1, "a"}},
+		{H{"id": 2, "name": "b"}, "SELECT * FROM t WHERE id IN (?) AND name=?", []interface{}{2, "b"}},
+		{H{"id": []int{1, 2}, "name": "c"}, "SELECT * FROM t WHERE id IN (?, ?) AND name=?", []interface{}{1, 2, "c"}},
+	}
+	for _, tt := range tests {
+		query, args, err := c.namedQuery("SELECT * FROM t WHERE id IN (:id) AND name=:name", tt.argMap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if query != tt.wantQuery || !reflect.DeepEqual(args, tt.wantArgs) {
+			t.Fatalf("namedQuery = %s %v, want %s %v", query, args, tt.wantQuery, tt.wantArgs)
+		}
+	}
+	_, _, err := c.namedQuery("SELECT * FROM t WHERE id IN (:id) AND name=:name", H{"id": 1})
+	if err == nil {
+		t.Fatalf("want missing arg error")
+	}
+}
+
+func TestDbStmtCacheInvalidate(t *testing.T) {
+	query := "UPDATE t SET a=? WHERE id=?"
+	tests := []struct {
+		name      string
+		setup     func(f *DbFake, c *DbStmtCache)
+		wantErr   bool
+		wantExecs int
+	}{
+		{
+			name: "cached",
+			setup: func(f *DbFake, c *DbStmtCache) {
+				f.Expect(`^UPDATE`).WillReturnResult(0, 1)
+			},
+			wantExecs: 1,
+		},
+		{
+			name: "stmt closed",
+			setup: func(f *DbFake, c *DbStmtCache) {
+				f.Expect(`^UPDATE`).WillReturnResult(0, 1)
+				v, _ := c.stmts.get(query)
+				_ = v.(*sqlx.Stmt).Close()
+			},
+			wantExecs: 1,
+		},
+		{
+			name: "mysql error",
+			setup: func(f *DbFake, c *DbStmtCache) {
+				f.Expect(`^UPDATE`).Once().WillReturnError(&mysql.MySQLError{Number: 1062})
+				f.Expect(`^UPDATE`).WillReturnResult(0, 1)
+			},
+			wantErr:   true,
+			wantExecs: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := DbNewFake()
+			defer f.Close()
+			c := DbNewStmtCache(f.DB, 0)
+			defer c.Close()
+			tx := DbWithStmtCache(f.DB, c)
+			ctx := context.Background()
+			// 预处理并缓存
+			f.Expect(`^UPDATE`).Once()
+			_, err := tx.ExecContext(ctx, query, 1, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Reset()
+			tt.setup(f, c)
+			_, err = tx.ExecContext(ctx, query, 1, 1)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(f.Records()) != tt.wantExecs {
+				t.Fatalf("execs = %v, want %d", f.Records(), tt.wantExecs)
+			}
+		})
+	}
+}
+
+func TestDbStmtCacheClosed(t *testing.T) {
+	f := DbNewFake()
+	defer f.Close()
+	f.Expect(`^UPDATE`).WillReturnResult(0, 1)
+	c := DbNewStmtCache(f.DB, 0)
+	_ = c.Close()
+	n, err := DbExecuteCountNamedContent(context.Background(), DbWithStmtCache(f.DB, c), "UPDATE t SET a=:a", H{"a": 1})
+	if err != nil || n != 1 {
+		t.Fatalf("n = %d, err = %v", n, err)
+	}
+}
+
+func TestDbStmtIsPreparable(t *testing.T) {
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"SELECT 1", true},
+		{"\n  select * FROM t", true},
+		{"(SELECT 1) UNION (SELECT 2)", true},
+		{"INSERT INTO t VALUES (?)", true},
+		{"update t SET a=?", true},
+		{"DELETE FROM t", true},
+		{"REPLACE INTO t VALUES (?)", true},
+		{"CREATE TABLE t (id int)", false},
+		{"ALTER TABLE t ADD COLUMN a int", false},
+		{"DROP TABLE t", false},
+		{"SELECTED", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		got := dbStmtIsPreparable(tt.query)
+		if got != tt.want {
+			t.Errorf("preparable %q = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestDbStmtCacheDDL(t *testing.T) {
+	f := DbNewFake()
+	defer f.Close()
+	f.Expect(`^CREATE TABLE`).WillReturnResult(0, 0)
+	c := DbNewStmtCache(f.DB, 0)
+	defer c.Close()
+	_, err := dbExecRaw(context.Background(), DbWithStmtCache(f.DB, c), "CREATE TABLE t (id int)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.stmts.ll.Len() != 0 {
+		t.Fatalf("ddl stmt cached")
+	}
+}
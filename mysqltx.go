@@ -0,0 +1,118 @@
+package mcommon
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// DbTxHookAble 可注册提交/回滚回调的事物
+type DbTxHookAble interface {
+	OnCommit(f func())
+	OnRollback(f func())
+}
+
+// dbTx DbTransaction 传给回调的事物对象
+type dbTx struct {
+	*sqlx.Tx
+
+	parent        *dbTx
+	mu            sync.Mutex
+	commitHooks   []func()
+	rollbackHooks []func()
+}
+
+// OnCommit 注册事物提交成功后执行的函数
+func (t *dbTx) OnCommit(f func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.commitHooks = append(t.commitHooks, f)
+}
+
+// OnRollback 注册事物回滚后执行的函数
+func (t *dbTx) OnRollback(f func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollbackHooks = append(t.rollbackHooks, f)
+}
+
+// runCommitHooks 执行提交回调
+func (t *dbTx) runCommitHooks() {
+	t.mu.Lock()
+	hooks := t.commitHooks
+	t.commitHooks = nil
+	t.rollbackHooks = nil
+	t.mu.Unlock()
+	runTxHooks(hooks)
+}
+
+// runRollbackHooks 执行回滚回调
+func (t *dbTx) runRollbackHooks() {
+	t.mu.Lock()
+	hooks := t.rollbackHooks
+	t.commitHooks = nil
+	t.rollbackHooks = nil
+	t.mu.Unlock()
+	runTxHooks(hooks)
+}
+
+// mergeHooksToParent 保存点释放后回调交给上层事物
+func (t *dbTx) mergeHooksToParent() {
+	t.mu.Lock()
+	commitHooks := t.commitHooks
+	rollbackHooks := t.rollbackHooks
+	t.commitHooks = nil
+	t.rollbackHooks = nil
+	t.mu.Unlock()
+
+	t.parent.mu.Lock()
+	defer t.parent.mu.Unlock()
+	t.parent.commitHooks = append(t.parent.commitHooks, commitHooks...)
+	t.parent.rollbackHooks = append(t.parent.rollbackHooks, rollbackHooks...)
+}
+
+// runTxHooks 依次执行回调, 单个回调panic不影响其他回调
+func runTxHooks(hooks []func()) {
+	for _, hook := range hooks {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					Log.Errorf("db tx hook panic: %v", r)
+				}
+			}()
+			hook()
+		}()
+	}
+}
+
+// DbOnCommit 注册事物提交后执行的函数
+// tx 不在事物中时立即执行
+func DbOnCommit(tx DbExeAble, f func()) error {
+	switch t := tx.(type) {
+	case dbWrapper:
+		return DbOnCommit(t.unwrapDb(), f)
+	case DbTxHookAble:
+		t.OnCommit(f)
+	case *sqlx.DB:
+		runTxHooks([]func(){f})
+	default:
+		return fmt.Errorf("no hook db type: %T", tx)
+	}
+	return nil
+}
+
+// DbOnRollback 注册事物回滚后执行的函数
+// tx 不在事物中时不会回滚, 忽略
+func DbOnRollback(tx DbExeAble, f func()) error {
+	switch t := tx.(type) {
+	case dbWrapper:
+		return DbOnRollback(t.unwrapDb(), f)
+	case DbTxHookAble:
+		t.OnRollback(f)
+	case *sqlx.DB:
+	default:
+		return fmt.Errorf("no hook db type: %T", tx)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package mcommon
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDbTxHooks(t *testing.T) {
+	errFail := errors.New("fail")
+	tests := []struct {
+		name     string
+		innerErr error
+		outerErr error
+		want     []string
+	}{
+		{
+			name: "commit",
+			want: []string{"outer commit", "inner commit"},
+		},
+		{
+			name:     "inner rollback",
+			innerErr: errFail,
+			want:     []string{"inner rollback", "outer commit"},
+		},
+		{
+			name:     "outer rollback",
+			outerErr: errFail,
+			want:     []string{"outer rollback", "inner rollback"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := DbNewFake()
+			defer f.Close()
+			var got []string
+			add := func(s string) func() {
+				return func() {
+					got = append(got, s)
+				}
+			}
+			_ = DbTransaction(context.Background(), f.DB, func(tx DbExeAble) error {
+				_ = DbOnCommit(tx, add("outer commit"))
+				_ = DbOnRollback(tx, add("outer rollback"))
+				_ = DbTransaction(context.Background(), tx, func(tx DbExeAble) error {
+					_ = DbOnCommit(tx, add("inner commit"))
+					_ = DbOnRollback(tx, add("inner rollback"))
+					return tt.innerErr
+				})
+				return tt.outerErr
+			})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("hooks = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDbOnCommitNoTx(t *testing.T) {
+	f := DbNewFake()
+	defer f.Close()
+	called := false
+	err := DbOnCommit(f.DB, func() {
+		called = true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !called {
+		t.Fatalf("commit hook not called without tx")
+	}
+}
+
+func TestDbTxHookPanic(t *testing.T) {
+	f := DbNewFake()
+	defer f.Close()
+	called := false
+	err := DbTransaction(context.Background(), f.DB, func(tx DbExeAble) error {
+		_ = DbOnCommit(tx, func() {
+			panic("hook")
+		})
+		_ = DbOnCommit(tx, func() {
+			called = true
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !called {
+		t.Fatalf("hook after panic not called")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // join类型
@@ -48,8 +49,9 @@ func (o QueryEq) ToSQL() ([]byte, map[string]interface{}, error) {
 	args := map[string]interface{}{}
 	buf.WriteString(o.K)
 	rt := reflect.TypeOf(o.V)
-	switch rt.Kind() {
-	case reflect.Slice:
+	_, isBytes := o.V.([]byte)
+	switch {
+	case rt != nil && rt.Kind() == reflect.Slice && !isBytes:
 		s := reflect.ValueOf(o.V)
 		if s.Len() == 0 {
 			return nil, nil, fmt.Errorf("in cond len 0")
@@ -77,6 +79,17 @@ func (o QueryEqRaw) ToSQL() ([]byte, map[string]interface{}, error) {
 	return buf.Bytes(), nil, nil
 }
 
+// QueryIsNull k IS NULL
+type QueryIsNull string
+
+// ToSQL 生成语句和参数
+func (o QueryIsNull) ToSQL() ([]byte, map[string]interface{}, error) {
+	var buf bytes.Buffer
+	buf.WriteString(string(o))
+	buf.WriteString(" IS NULL")
+	return buf.Bytes(), nil, nil
+}
+
 // QueryColumn 查询字段
 type QueryColumn string
 
@@ -164,9 +177,11 @@ func (o QueryAsc) ToSQL() ([]byte, map[string]interface{}, error) {
 }
 
 type joinData struct {
-	joinType int64
-	obj      string
-	onParts  []QueryMaker
+	joinType      int64
+	obj           string
+	onParts       []QueryMaker
+	withTrashed   bool
+	withoutTenant bool
 }
 
 // QueryJoin 链接
@@ -184,6 +199,18 @@ func (j *joinData) On(cond QueryMaker) *joinData {
 	return j
 }
 
+// WithTrashed 包含软删除的数据
+func (j *joinData) WithTrashed() *joinData {
+	j.withTrashed = true
+	return j
+}
+
+// WithoutTenant 不增加租户条件
+func (j *joinData) WithoutTenant() *joinData {
+	j.withoutTenant = true
+	return j
+}
+
 // ToSQL 生成sql
 func (j *joinData) ToSQL() ([]byte, map[string]interface{}, error) {
 	var buf bytes.Buffer
@@ -204,7 +231,20 @@ func (j *joinData) ToSQL() ([]byte, map[string]interface{}, error) {
 	if len(j.onParts) == 0 {
 		return nil, nil, fmt.Errorf("no joinData on condiation")
 	}
-	for i, on := range j.onParts {
+	onParts := j.onParts
+	if !j.withTrashed {
+		column, ok := querySoftDeleteColumn(j.obj)
+		if ok {
+			onParts = append(onParts[:len(onParts):len(onParts)], QueryIsNull(column))
+		}
+	}
+	if !j.withoutTenant {
+		column, ok := queryTenantColumn(j.obj)
+		if ok {
+			onParts = append(onParts[:len(onParts):len(onParts)], queryTenantCond(column))
+		}
+	}
+	for i, on := range onParts {
 		buf.WriteString("\n    ")
 		if i != 0 {
 			buf.WriteString("AND ")
@@ -223,15 +263,17 @@ func (j *joinData) ToSQL() ([]byte, map[string]interface{}, error) {
 }
 
 type selectData struct {
-	columns      []QueryMaker
-	from         string
-	whereParts   []QueryMaker
-	groupBys     []string
-	orderByParts []QueryMaker
-	offset       int64
-	limit        int64
-	isForUpdate  bool
-	joins        []QueryMaker
+	columns       []QueryMaker
+	from          string
+	whereParts    []QueryMaker
+	groupBys      []string
+	orderByParts  []QueryMaker
+	offset        int64
+	limit         int64
+	isForUpdate   bool
+	joins         []QueryMaker
+	withTrashed   bool
+	withoutTenant bool
 }
 
 // QuerySelect 创建搜索
@@ -289,6 +331,18 @@ func (q *selectData) ForUpdate() *selectData {
 	return q
 }
 
+// WithTrashed 包含软删除的数据
+func (q *selectData) WithTrashed() *selectData {
+	q.withTrashed = true
+	return q
+}
+
+// WithoutTenant 不增加租户条件
+func (q *selectData) WithoutTenant() *selectData {
+	q.withoutTenant = true
+	return q
+}
+
 // ToSQL 生成sql
 func (q *selectData) ToSQL() ([]byte, map[string]interface{}, error) {
 	var buf bytes.Buffer
@@ -332,9 +386,22 @@ func (q *selectData) ToSQL() ([]byte, map[string]interface{}, error) {
 			}
 		}
 	}
-	if len(q.whereParts) > 0 {
+	whereParts := q.whereParts
+	if !q.withTrashed {
+		column, ok := querySoftDeleteColumn(q.from)
+		if ok {
+			whereParts = append(whereParts[:len(whereParts):len(whereParts)], QueryIsNull(column))
+		}
+	}
+	if !q.withoutTenant {
+		column, ok := queryTenantColumn(q.from)
+		if ok {
+			whereParts = append(whereParts[:len(whereParts):len(whereParts)], queryTenantCond(column))
+		}
+	}
+	if len(whereParts) > 0 {
 		buf.WriteString("\nWHERE")
-		for i, where := range q.whereParts {
+		for i, where := range whereParts {
 			buf.WriteString("\n    ")
 			if i != 0 {
 				buf.WriteString("AND ")
@@ -394,6 +461,7 @@ type insertData struct {
 	columns        []string
 	values         []interface{}
 	duplicateParts []QueryMaker
+	withoutTenant  bool
 }
 
 // QueryInsert 创建搜索
@@ -427,6 +495,12 @@ func (q *insertData) Duplicates(duplicates ...QueryMaker) *insertData {
 	return q
 }
 
+// WithoutTenant 不自动填充租户列
+func (q *insertData) WithoutTenant() *insertData {
+	q.withoutTenant = true
+	return q
+}
+
 // ToSQL 生成sql
 func (q *insertData) ToSQL() ([]byte, map[string]interface{}, error) {
 	var buf bytes.Buffer
@@ -443,9 +517,38 @@ func (q *insertData) ToSQL() ([]byte, map[string]interface{}, error) {
 	if len(q.columns) == 0 {
 		return nil, nil, fmt.Errorf("no insert columns")
 	}
+	columns := q.columns
+	values := q.values
+	if !q.withoutTenant {
+		tenantColumn, ok := queryTenantRawColumn(q.into)
+		if ok {
+			tenantIndex := -1
+			for i, column := range columns {
+				if strings.Trim(column, "`") == tenantColumn {
+					tenantIndex = i
+				}
+			}
+			if tenantIndex < 0 {
+				// 每行的值后增加租户占位
+				columns = append(columns[:len(columns):len(columns)], tenantColumn)
+			}
+			values = make([]interface{}, len(q.values))
+			for i, value := range q.values {
+				rowValues := value.([]interface{})
+				if tenantIndex < 0 {
+					values[i] = append(rowValues[:len(rowValues):len(rowValues)], queryTenantValue{})
+					continue
+				}
+				// 显式传入的租户需要和上下文一致
+				rowValues = append([]interface{}{}, rowValues...)
+				rowValues[tenantIndex] = queryTenantExplicit(rowValues[tenantIndex])
+				values[i] = rowValues
+			}
+		}
+	}
 	buf.WriteString(" (")
-	lastColumnIndex := len(q.columns) - 1
-	for i, column := range q.columns {
+	lastColumnIndex := len(columns) - 1
+	for i, column := range columns {
 		buf.WriteString("\n    ")
 		buf.WriteString(column)
 		if i != lastColumnIndex {
@@ -453,11 +556,11 @@ func (q *insertData) ToSQL() ([]byte, map[string]interface{}, error) {
 		}
 	}
 	buf.WriteString("\n) VALUES")
-	if len(q.values) == 0 {
+	if len(values) == 0 {
 		return nil, nil, fmt.Errorf("insert values emputy")
 	}
-	lastValueIndex := len(q.values) - 1
-	for i, value := range q.values {
+	lastValueIndex := len(values) - 1
+	for i, value := range values {
 		k := fmt.Sprintf("value%d", i)
 		buf.WriteString("\n(:")
 		buf.WriteString(k)
@@ -489,9 +592,11 @@ func (q *insertData) ToSQL() ([]byte, map[string]interface{}, error) {
 }
 
 type updateData struct {
-	table       string
-	updateParts []QueryMaker
-	whereParts  []QueryMaker
+	table         string
+	updateParts   []QueryMaker
+	whereParts    []QueryMaker
+	withTrashed   bool
+	withoutTenant bool
 }
 
 // QueryUpdate 创建更新
@@ -513,6 +618,18 @@ func (q *updateData) Where(whereParts ...QueryMaker) *updateData {
 	return q
 }
 
+// WithTrashed 包含软删除的数据
+func (q *updateData) WithTrashed() *updateData {
+	q.withTrashed = true
+	return q
+}
+
+// WithoutTenant 不增加租户条件
+func (q *updateData) WithoutTenant() *updateData {
+	q.withoutTenant = true
+	return q
+}
+
 // ToSQL 生成sql
 func (q *updateData) ToSQL() ([]byte, map[string]interface{}, error) {
 	var buf bytes.Buffer
@@ -529,6 +646,11 @@ func (q *updateData) ToSQL() ([]byte, map[string]interface{}, error) {
 	}
 	lastUpdateIndex := len(q.updateParts) - 1
 	for i, updatePart := range q.updateParts {
+		if eq, ok := updatePart.(QueryEq); ok && !q.withoutTenant && queryTenantIsColumn(q.table, eq.K) {
+			// 更新租户列时需要和上下文一致
+			eq.V = queryTenantExplicit(eq.V)
+			updatePart = eq
+		}
 		tQuery, tArgMap, err := updatePart.ToSQL()
 		if err != nil {
 			return nil, nil, err
@@ -542,9 +664,22 @@ func (q *updateData) ToSQL() ([]byte, map[string]interface{}, error) {
 			args[tk] = tv
 		}
 	}
-	if len(q.whereParts) > 0 {
+	whereParts := q.whereParts
+	if !q.withTrashed {
+		column, ok := querySoftDeleteColumn(q.table)
+		if ok {
+			whereParts = append(whereParts[:len(whereParts):len(whereParts)], QueryIsNull(column))
+		}
+	}
+	if !q.withoutTenant {
+		column, ok := queryTenantColumn(q.table)
+		if ok {
+			whereParts = append(whereParts[:len(whereParts):len(whereParts)], queryTenantCond(column))
+		}
+	}
+	if len(whereParts) > 0 {
 		buf.WriteString("\nWHERE")
-		for i, where := range q.whereParts {
+		for i, where := range whereParts {
 			buf.WriteString("\n    ")
 			if i != 0 {
 				buf.WriteString("AND ")
@@ -563,8 +698,10 @@ func (q *updateData) ToSQL() ([]byte, map[string]interface{}, error) {
 }
 
 type deleteData struct {
-	table      string
-	whereParts []QueryMaker
+	table         string
+	whereParts    []QueryMaker
+	isHardDelete  bool
+	withoutTenant bool
 }
 
 // QueryDelete 创建删除
@@ -580,8 +717,32 @@ func (q *deleteData) Where(whereParts ...QueryMaker) *deleteData {
 	return q
 }
 
-// ToSQL 生成sql
+// HardDelete 软删除的表也执行物理删除
+func (q *deleteData) HardDelete() *deleteData {
+	q.isHardDelete = true
+	return q
+}
+
+// WithoutTenant 不增加租户条件
+func (q *deleteData) WithoutTenant() *deleteData {
+	q.withoutTenant = true
+	return q
+}
+
+// ToSQL 生成sql, 软删除的表生成设置删除时间的更新语句
 func (q *deleteData) ToSQL() ([]byte, map[string]interface{}, error) {
+	if !q.isHardDelete {
+		column, ok := querySoftDeleteColumn(q.table)
+		if ok {
+			u := QueryUpdate(q.table).
+				Update(QueryEq{K: column, V: time.Now()}).
+				Where(q.whereParts...)
+			if q.withoutTenant {
+				u.WithoutTenant()
+			}
+			return u.ToSQL()
+		}
+	}
 	var buf bytes.Buffer
 	args := map[string]interface{}{}
 
@@ -590,9 +751,16 @@ func (q *deleteData) ToSQL() ([]byte, map[string]interface{}, error) {
 		return nil, nil, fmt.Errorf("no update table")
 	}
 	buf.WriteString(q.table)
-	if len(q.whereParts) > 0 {
+	whereParts := q.whereParts
+	if !q.withoutTenant {
+		column, ok := queryTenantColumn(q.table)
+		if ok {
+			whereParts = append(whereParts[:len(whereParts):len(whereParts)], queryTenantCond(column))
+		}
+	}
+	if len(whereParts) > 0 {
 		buf.WriteString("\nWHERE")
-		for i, where := range q.whereParts {
+		for i, where := range whereParts {
 			buf.WriteString("\n    ")
 			if i != 0 {
 				buf.WriteString("AND ")
@@ -0,0 +1,65 @@
+package mcommon
+
+import (
+	"strings"
+	"sync"
+)
+
+// querySoftDeleteTables 软删除表配置, 表名 -> 删除时间列
+var querySoftDeleteTables = map[string]string{}
+
+// querySoftDeleteLock 软删除配置锁
+var querySoftDeleteLock sync.RWMutex
+
+// QuerySetSoftDelete 设置表使用软删除, column 为删除时间列
+// 设置后 QuerySelect/QueryUpdate 自动增加 column IS NULL 条件, QueryDelete/DbDeleteKV 改为设置删除时间
+func QuerySetSoftDelete(table string, column string) {
+	querySoftDeleteLock.Lock()
+	defer querySoftDeleteLock.Unlock()
+	querySoftDeleteTables[table] = column
+}
+
+// QueryRemoveSoftDelete 取消表的软删除设置
+func QueryRemoveSoftDelete(table string) {
+	querySoftDeleteLock.Lock()
+	defer querySoftDeleteLock.Unlock()
+	delete(querySoftDeleteTables, table)
+}
+
+// querySoftDeleteColumn 获取表的软删除列, 返回带表名或别名前缀的列名, 分表使用逻辑表的设置
+func querySoftDeleteColumn(obj string) (string, bool) {
+	table, prefix := queryParseTable(obj)
+	logicalTable := dbShardLogicalTable(table)
+	querySoftDeleteLock.RLock()
+	column, ok := querySoftDeleteTables[table]
+	if !ok {
+		column, ok = querySoftDeleteTables[logicalTable]
+	}
+	querySoftDeleteLock.RUnlock()
+	if !ok {
+		return "", false
+	}
+	return prefix + "." + column, true
+}
+
+// queryParseTable 解析表名和引用前缀
+// obj 格式为 table, table alias 或 table AS alias, 表名可以带库名和反引号
+func queryParseTable(obj string) (string, string) {
+	fields := strings.Fields(obj)
+	if len(fields) == 0 {
+		return "", ""
+	}
+	table := fields[0]
+	if i := strings.LastIndex(table, "."); i >= 0 {
+		table = table[i+1:]
+	}
+	table = strings.Trim(table, "`")
+	prefix := fields[0]
+	switch {
+	case len(fields) == 2:
+		prefix = fields[1]
+	case len(fields) == 3 && strings.EqualFold(fields[1], "AS"):
+		prefix = fields[2]
+	}
+	return table, prefix
+}
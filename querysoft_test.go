@@ -0,0 +1,104 @@
+package mcommon
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestQuerySoftDelete(t *testing.T) {
+	QuerySetSoftDelete("t_soft", "deleted_at")
+	defer QueryRemoveSoftDelete("t_soft")
+	tests := []struct {
+		name    string
+		q       QueryMaker
+		want    []string
+		notWant []string
+	}{
+		{
+			name: "select",
+			q:    QuerySelect(QueryColumn("id")).From("t_soft").Where(QueryEq{K: "id", V: 1}),
+			want: []string{"t_soft.deleted_at IS NULL"},
+		},
+		{
+			name: "select alias",
+			q:    QuerySelect(QueryColumn("s.id")).From("t_soft AS s"),
+			want: []string{"s.deleted_at IS NULL"},
+		},
+		{
+			name:    "select with trashed",
+			q:       QuerySelect(QueryColumn("id")).From("t_soft").WithTrashed(),
+			notWant: []string{"deleted_at"},
+		},
+		{
+			name:    "other table",
+			q:       QuerySelect(QueryColumn("id")).From("t_hard"),
+			notWant: []string{"deleted_at"},
+		},
+		{
+			name: "join",
+			q: QuerySelect(QueryColumn("h.id")).From("t_hard h").
+				Join(QueryJoin(1, "t_soft s").On(QueryEqRaw{K: "s.id", V: "h.id"})),
+			want: []string{"s.deleted_at IS NULL"},
+		},
+		{
+			name: "update",
+			q:    QueryUpdate("t_soft").Update(QueryEq{K: "a", V: 1}).Where(QueryEq{K: "id", V: 1}),
+			want: []string{"UPDATE", "t_soft.deleted_at IS NULL"},
+		},
+		{
+			name:    "delete",
+			q:       QueryDelete("t_soft").Where(QueryEq{K: "id", V: 1}),
+			want:    []string{"UPDATE", "t_soft.deleted_at=:t_soft_deleted_at"},
+			notWant: []string{"DELETE"},
+		},
+		{
+			name:    "hard delete",
+			q:       QueryDelete("t_soft").Where(QueryEq{K: "id", V: 1}).HardDelete(),
+			want:    []string{"DELETE"},
+			notWant: []string{"deleted_at"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, _, err := tt.q.ToSQL()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range tt.want {
+				if !strings.Contains(string(query), s) {
+					t.Errorf("query missing %q:\n%s", s, query)
+				}
+			}
+			for _, s := range tt.notWant {
+				if strings.Contains(string(query), s) {
+					t.Errorf("query contains %q:\n%s", s, query)
+				}
+			}
+		})
+	}
+}
+
+func TestDbDeleteKVSoft(t *testing.T) {
+	QuerySetSoftDelete("t_soft", "deleted_at")
+	defer QueryRemoveSoftDelete("t_soft")
+	tests := []struct {
+		name    string
+		table   string
+		pattern string
+	}{
+		{"soft", "t_soft", `(?s)^UPDATE\nt_soft\nSET\nt_soft\.deleted_at=\?\nWHERE\nid=\?\nAND t_soft\.deleted_at IS NULL`},
+		{"hard", "t_hard", `^DELETE\nFROM\nt_hard\nWHERE\nid=\?`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := DbNewFake()
+			defer f.Close()
+			f.Expect(tt.pattern).WillReturnResult(0, 1)
+			n, err := DbDeleteKV(context.Background(), f.DB, tt.table, []string{"id"}, []interface{}{1})
+			if err != nil || n != 1 {
+				t.Fatalf("n = %d, err = %v", n, err)
+			}
+		})
+	}
+}
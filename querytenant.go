@@ -0,0 +1,291 @@
+package mcommon
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ErrDbNoTenant 访问租户表时上下文中没有租户
+var ErrDbNoTenant = errors.New("no tenant in context")
+
+// ErrDbTenantMismatch 显式传入的租户和上下文中的租户不一致
+var ErrDbTenantMismatch = errors.New("tenant mismatch with context")
+
+// ErrDbTenantUnscoped 直接写的sql访问租户表但没有使用租户参数占位
+var ErrDbTenantUnscoped = errors.New("sql on tenant table without tenant arg")
+
+// queryTenantTables 租户表配置, 表名 -> 租户列
+var queryTenantTables = map[string]string{}
+
+// queryTenantRegexps 检查sql是否访问租户表的正则
+var queryTenantRegexps = map[string]*regexp.Regexp{}
+
+// queryTenantLock 租户配置锁
+var queryTenantLock sync.RWMutex
+
+// dbTenantCtxKey 上下文中租户的key
+type dbTenantCtxKey struct{}
+
+// dbWithoutTenantCtxKey 上下文中跳过租户检查的key
+type dbWithoutTenantCtxKey struct{}
+
+// queryTenantValue 租户参数占位, 由Db执行函数替换为上下文中的租户
+type queryTenantValue struct {
+	// value 显式传入的租户, 需要和上下文中的租户一致
+	value interface{}
+	isSet bool
+}
+
+// QuerySetTenantTable 设置表按租户隔离, column 为租户列
+// 设置后 QuerySelect/QueryUpdate/QueryDelete 自动增加租户条件, QueryInsert 自动填充租户列,
+// DbUpdateKV/DbDeleteKV 自动增加租户条件, 租户从执行时的上下文中读取
+// 直接写的sql访问该表时需要使用 DbTenantArg 作为租户参数, 否则返回 ErrDbTenantUnscoped
+func QuerySetTenantTable(table string, column string) {
+	queryTenantLock.Lock()
+	defer queryTenantLock.Unlock()
+	queryTenantTables[table] = column
+	// 匹配 FROM t, FROM a, t, JOIN t, UPDATE t, INTO t 等, 表名可以带库名, 反引号和别名
+	// 分表后缀 t_01 在检查时确认是否为该表的分表
+	queryTenantRegexps[table] = regexp.MustCompile("(?i)\\b(FROM|JOIN|UPDATE|INTO)\\s+" +
+		"((`?\\w+`?\\.)?`?\\w+`?(\\s+(AS\\s+)?`?\\w+`?)?\\s*,\\s*)*" +
+		"(`?\\w+`?\\.)?`?" + regexp.QuoteMeta(table) + "(?P<shard>_\\d+)?`?(\\s|,|\\)|;|$)")
+}
+
+// QueryRemoveTenantTable 取消表的租户设置
+func QueryRemoveTenantTable(table string) {
+	queryTenantLock.Lock()
+	defer queryTenantLock.Unlock()
+	delete(queryTenantTables, table)
+	delete(queryTenantRegexps, table)
+}
+
+// DbWithTenant 在上下文中设置租户
+func DbWithTenant(ctx context.Context, tenantID interface{}) context.Context {
+	return context.WithValue(ctx, dbTenantCtxKey{}, tenantID)
+}
+
+// DbTenantFromContext 获取上下文中的租户
+func DbTenantFromContext(ctx context.Context) (interface{}, bool) {
+	tenantID := ctx.Value(dbTenantCtxKey{})
+	return tenantID, tenantID != nil
+}
+
+// DbTenantArg 租户参数占位, 执行时替换为上下文中的租户, 用于直接写的sql
+// 如 DbSelectNamedContent(ctx, tx, &rows, "SELECT * FROM t WHERE tenant_id=:tenant_id", H{"tenant_id": DbTenantArg()})
+func DbTenantArg() interface{} {
+	return queryTenantValue{}
+}
+
+// DbWithoutTenant 允许没有租户的上下文访问租户表, 用于后台等跨租户操作
+// 生成器也需要调用 WithoutTenant 才不会增加租户条件
+func DbWithoutTenant(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dbWithoutTenantCtxKey{}, true)
+}
+
+// queryTenantColumn 获取表的租户列, 返回带表名或别名前缀的列名
+func queryTenantColumn(obj string) (string, bool) {
+	table, prefix := queryParseTable(obj)
+	column, ok := queryTenantRawColumn(table)
+	if !ok {
+		return "", false
+	}
+	return prefix + "." + column, true
+}
+
+// queryTenantRawColumn 获取表的租户列, 分表使用逻辑表的设置
+func queryTenantRawColumn(table string) (string, bool) {
+	logicalTable := dbShardLogicalTable(table)
+	queryTenantLock.RLock()
+	defer queryTenantLock.RUnlock()
+	column, ok := queryTenantTables[table]
+	if !ok {
+		column, ok = queryTenantTables[logicalTable]
+	}
+	return column, ok
+}
+
+// queryTenantCond 租户条件 k=:mcommon_tenant_k
+type queryTenantCond string
+
+// ToSQL 生成语句和参数
+func (o queryTenantCond) ToSQL() ([]byte, map[string]interface{}, error) {
+	k := "mcommon_tenant_" + getK(string(o))
+
+	var buf bytes.Buffer
+	buf.WriteString(string(o))
+	buf.WriteString("=:")
+	buf.WriteString(k)
+	return buf.Bytes(), map[string]interface{}{k: queryTenantValue{}}, nil
+}
+
+// queryTenantExplicit 租户列显式传入的值改为需要检查的占位
+func queryTenantExplicit(v interface{}) interface{} {
+	if tv, ok := v.(queryTenantValue); ok {
+		return tv
+	}
+	return queryTenantValue{value: v, isSet: true}
+}
+
+// queryTenantIsColumn k 是否为表的租户列, k 可以带表名或别名前缀
+func queryTenantIsColumn(obj string, k string) bool {
+	column, ok := queryTenantColumn(obj)
+	if !ok {
+		return false
+	}
+	rawColumn := column[strings.LastIndex(column, ".")+1:]
+	k = strings.Trim(k, "`")
+	return k == column || k == rawColumn
+}
+
+// dbTenantKV 租户表的条件中增加租户列
+func dbTenantKV(table string, keys []string, values []interface{}) ([]string, []interface{}) {
+	column, ok := queryTenantColumn(table)
+	if !ok {
+		return keys, values
+	}
+	newKeys := append(append([]string{}, keys...), column)
+	newValues := append(append([]interface{}{}, values...), queryTenantValue{})
+	return newKeys, newValues
+}
+
+// dbTenantValue 获取占位对应的租户
+// 显式传入的租户需要和上下文一致, 上下文中没有租户时只有 DbWithoutTenant 允许使用显式传入的租户
+func dbTenantValue(ctx context.Context, tv queryTenantValue) (interface{}, error) {
+	tenantID, ok := DbTenantFromContext(ctx)
+	if !ok {
+		if tv.isSet && ctx.Value(dbWithoutTenantCtxKey{}) != nil {
+			return tv.value, nil
+		}
+		return nil, ErrDbNoTenant
+	}
+	if tv.isSet && fmt.Sprint(tv.value) != fmt.Sprint(tenantID) {
+		return nil, ErrDbTenantMismatch
+	}
+	return tenantID, nil
+}
+
+// dbTenantReplace 替换租户参数占位, 返回是否包含占位
+func dbTenantReplace(ctx context.Context, v interface{}) (interface{}, bool, error) {
+	switch tv := v.(type) {
+	case queryTenantValue:
+		tenantID, err := dbTenantValue(ctx, tv)
+		return tenantID, true, err
+	case []interface{}:
+		var newValues []interface{}
+		for i, item := range tv {
+			itemValue, ok := item.(queryTenantValue)
+			if !ok {
+				continue
+			}
+			tenantID, err := dbTenantValue(ctx, itemValue)
+			if err != nil {
+				return nil, true, err
+			}
+			if newValues == nil {
+				newValues = append([]interface{}{}, tv...)
+			}
+			newValues[i] = tenantID
+		}
+		if newValues != nil {
+			return newValues, true, nil
+		}
+	}
+	return v, false, nil
+}
+
+// queryTenantMatch sql是否访问了租户表或其分表
+func queryTenantMatch(r *regexp.Regexp, table string, query string) bool {
+	shardIndex := 0
+	for i, name := range r.SubexpNames() {
+		if name == "shard" {
+			shardIndex = i
+		}
+	}
+	for _, match := range r.FindAllStringSubmatch(query, -1) {
+		shard := match[shardIndex]
+		if shard == "" || dbShardLogicalTable(table+shard) == table {
+			return true
+		}
+	}
+	return false
+}
+
+// dbTenantCheck 检查没有使用租户占位的sql是否访问了租户表
+func dbTenantCheck(ctx context.Context, query string) error {
+	if ctx.Value(dbWithoutTenantCtxKey{}) != nil {
+		return nil
+	}
+	queryTenantLock.RLock()
+	defer queryTenantLock.RUnlock()
+	for table, r := range queryTenantRegexps {
+		if !queryTenantMatch(r, table, query) {
+			continue
+		}
+		if _, ok := DbTenantFromContext(ctx); ok {
+			return ErrDbTenantUnscoped
+		}
+		return ErrDbNoTenant
+	}
+	return nil
+}
+
+// dbTenantArgMap 将命名参数中的租户占位替换为上下文中的租户
+// 没有占位但访问了租户表的sql返回 ErrDbNoTenant 或 ErrDbTenantUnscoped
+func dbTenantArgMap(ctx context.Context, query string, argMap map[string]interface{}) (map[string]interface{}, error) {
+	var newArgMap map[string]interface{}
+	for k, v := range argMap {
+		newV, ok, err := dbTenantReplace(ctx, v)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		if newArgMap == nil {
+			newArgMap = make(map[string]interface{}, len(argMap))
+			for ok, ov := range argMap {
+				newArgMap[ok] = ov
+			}
+		}
+		newArgMap[k] = newV
+	}
+	if newArgMap != nil {
+		return newArgMap, nil
+	}
+	err := dbTenantCheck(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return argMap, nil
+}
+
+// dbTenantArgs 将位置参数中的租户占位替换为上下文中的租户
+func dbTenantArgs(ctx context.Context, query string, args []interface{}) ([]interface{}, error) {
+	var newArgs []interface{}
+	for i, arg := range args {
+		newArg, ok, err := dbTenantReplace(ctx, arg)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		if newArgs == nil {
+			newArgs = append([]interface{}{}, args...)
+		}
+		newArgs[i] = newArg
+	}
+	if newArgs != nil {
+		return newArgs, nil
+	}
+	err := dbTenantCheck(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return args, nil
+}
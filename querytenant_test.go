@@ -0,0 +1,238 @@
+package mcommon
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestQueryTenantRegexp(t *testing.T) {
+	QuerySetTenantTable("t_order", "tenant_id")
+	defer QueryRemoveTenantTable("t_order")
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"SELECT * FROM t_order WHERE id=1", true},
+		{"SELECT * FROM `t_order`", true},
+		{"SELECT * FROM db.t_order", true},
+		{"SELECT * FROM t_user u JOIN t_order o ON o.uid=u.id", true},
+		{"SELECT * FROM t_user, t_order WHERE 1", true},
+		{"SELECT * FROM t_user u, `t_order` o WHERE 1", true},
+		{"SELECT * FROM t_user AS u , t_goods AS g, t_order", true},
+		{"UPDATE t_user, t_order SET a=1", true},
+		{"INSERT INTO t_order (a) VALUES (1)", true},
+		{"DELETE FROM t_order", true},
+		{"SELECT * FROM t_order_item", false},
+		{"SELECT * FROM t_user WHERE name='t_order'", false},
+		{"SELECT t_order FROM t_user", false},
+	}
+	for _, tt := range tests {
+		got := queryTenantRegexps["t_order"].MatchString(tt.query)
+		if got != tt.want {
+			t.Errorf("match %q = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestDbTenantArgMap(t *testing.T) {
+	QuerySetTenantTable("t_order", "tenant_id")
+	defer QueryRemoveTenantTable("t_order")
+	base := context.Background()
+	tenant := DbWithTenant(base, int64(7))
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		query   string
+		argMap  map[string]interface{}
+		want    map[string]interface{}
+		wantErr error
+	}{
+		{
+			name:   "placeholder",
+			ctx:    tenant,
+			query:  "SELECT * FROM t_order WHERE tenant_id=:t",
+			argMap: H{"t": DbTenantArg()},
+			want:   H{"t": int64(7)},
+		},
+		{
+			name:    "placeholder no tenant",
+			ctx:     base,
+			query:   "SELECT * FROM t_order WHERE tenant_id=:t",
+			argMap:  H{"t": DbTenantArg()},
+			wantErr: ErrDbNoTenant,
+		},
+		{
+			name:    "raw no tenant",
+			ctx:     base,
+			query:   "SELECT * FROM t_order",
+			wantErr: ErrDbNoTenant,
+		},
+		{
+			name:    "raw with tenant",
+			ctx:     tenant,
+			query:   "SELECT * FROM t_order WHERE tenant_id=:t",
+			argMap:  H{"t": 8},
+			wantErr: ErrDbTenantUnscoped,
+		},
+		{
+			name:    "raw comma join",
+			ctx:     tenant,
+			query:   "SELECT * FROM t_user u, t_order o WHERE o.uid=u.id",
+			wantErr: ErrDbTenantUnscoped,
+		},
+		{
+			name:   "raw without tenant",
+			ctx:    DbWithoutTenant(tenant),
+			query:  "SELECT * FROM t_order",
+			argMap: H{},
+			want:   H{},
+		},
+		{
+			name:   "other table",
+			ctx:    base,
+			query:  "SELECT * FROM t_user WHERE id=:id",
+			argMap: H{"id": 1},
+			want:   H{"id": 1},
+		},
+		{
+			name:   "explicit same",
+			ctx:    tenant,
+			query:  "INSERT INTO t_order (tenant_id) VALUES (:v)",
+			argMap: H{"v": []interface{}{queryTenantExplicit(7)}},
+			want:   H{"v": []interface{}{int64(7)}},
+		},
+		{
+			name:    "explicit mismatch",
+			ctx:     tenant,
+			query:   "INSERT INTO t_order (tenant_id) VALUES (:v)",
+			argMap:  H{"v": []interface{}{queryTenantExplicit(8)}},
+			wantErr: ErrDbTenantMismatch,
+		},
+		{
+			name:    "explicit no tenant",
+			ctx:     base,
+			query:   "INSERT INTO t_order (tenant_id) VALUES (:v)",
+			argMap:  H{"v": []interface{}{queryTenantExplicit(8)}},
+			wantErr: ErrDbNoTenant,
+		},
+		{
+			name:   "explicit without tenant",
+			ctx:    DbWithoutTenant(base),
+			query:  "INSERT INTO t_order (tenant_id) VALUES (:v)",
+			argMap: H{"v": []interface{}{queryTenantExplicit(8)}},
+			want:   H{"v": []interface{}{8}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := dbTenantArgMap(tt.ctx, tt.query, tt.argMap)
+			if err != tt.wantErr {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("argMap = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryTenantBuilders(t *testing.T) {
+	QuerySetTenantTable("t_order", "tenant_id")
+	defer QueryRemoveTenantTable("t_order")
+	tenant := DbWithTenant(context.Background(), int64(7))
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		q       QueryMaker
+		pattern string
+		args    []interface{}
+		wantErr error
+	}{
+		{
+			name:    "select",
+			ctx:     tenant,
+			q:       QuerySelect(QueryColumn("id")).From("t_order").Where(QueryEq{K: "id", V: 1}),
+			pattern: `(?s)^SELECT.*t_order\.tenant_id=\?`,
+			args:    []interface{}{int64(1), int64(7)},
+		},
+		{
+			name:    "insert",
+			ctx:     tenant,
+			q:       QueryInsert("t_order").Columns("a").Values(1),
+			pattern: `(?s)^INSERT INTO t_order \(\s+a,\s+tenant_id\s+\)`,
+			args:    []interface{}{int64(1), int64(7)},
+		},
+		{
+			name:    "insert explicit",
+			ctx:     tenant,
+			q:       QueryInsert("t_order").Columns("a", "tenant_id").Values(1, 7),
+			pattern: `^INSERT`,
+			args:    []interface{}{int64(1), int64(7)},
+		},
+		{
+			name:    "insert explicit mismatch",
+			ctx:     tenant,
+			q:       QueryInsert("t_order").Columns("a", "tenant_id").Values(1, 8),
+			wantErr: ErrDbTenantMismatch,
+		},
+		{
+			name:    "update tenant column",
+			ctx:     tenant,
+			q:       QueryUpdate("t_order").Update(QueryEq{K: "tenant_id", V: 8}).Where(QueryEq{K: "id", V: 1}),
+			wantErr: ErrDbTenantMismatch,
+		},
+		{
+			name:    "no tenant",
+			ctx:     context.Background(),
+			q:       QueryDelete("t_order").Where(QueryEq{K: "id", V: 1}),
+			wantErr: ErrDbNoTenant,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := DbNewFake()
+			defer f.Close()
+			if tt.pattern != "" {
+				f.Expect(tt.pattern).WithArgs(tt.args...).WillReturnResult(0, 1).WillReturnRows([]string{"id"})
+			}
+			query, argMap, err := tt.q.ToSQL()
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = DbExecuteCountNamedContent(tt.ctx, f.DB, string(query), argMap)
+			if err != tt.wantErr {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil && len(f.Records()) != 0 {
+				t.Fatalf("sql executed: %v", f.Records())
+			}
+		})
+	}
+}
+
+func TestDbUpdateKVTenant(t *testing.T) {
+	QuerySetTenantTable("t_order", "tenant_id")
+	defer QueryRemoveTenantTable("t_order")
+	tenant := DbWithTenant(context.Background(), int64(7))
+	tests := []struct {
+		name      string
+		updateMap H
+		wantErr   error
+	}{
+		{"ok", H{"a": 1}, nil},
+		{"same tenant", H{"tenant_id": 7}, nil},
+		{"move tenant", H{"tenant_id": 8}, ErrDbTenantMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := DbNewFake()
+			defer f.Close()
+			f.Expect(`(?s)^UPDATE\nt_order.*AND t_order\.tenant_id=\?`).WillReturnResult(0, 1)
+			_, err := DbUpdateKV(tenant, f.DB, "t_order", tt.updateMap, []string{"id"}, []interface{}{1})
+			if err != tt.wantErr {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -27,7 +27,7 @@ func RedisCreate(address string, password string, dbIndex int) *redis.Client {
 	return client
 }
 
-// RedisSetBaseKey 设置基础key
+// RedisSetBaseKey 设置基础key, 全局生效, 需要不同前缀时使用 RedisStore
 func RedisSetBaseKey(v string) {
 	baseKey = v
 }
@@ -0,0 +1,274 @@
+package mcommon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	jsoniter "github.com/json-iterator/go"
+)
+
+// ErrRedisNotFound loader 返回此错误时缓存空结果, 缓存命中空结果时 Remember 也返回此错误
+var ErrRedisNotFound = errors.New("redis remember not found")
+
+// RedisRememberOptions 缓存加载选项
+type RedisRememberOptions struct {
+	// NegativeTTL 缓存空结果的时间, 0 为不缓存
+	NegativeTTL time.Duration
+	// Jitter 过期时间随机增加的比例, 如 0.1 为最多增加10%, 避免同时过期
+	Jitter float64
+	// StaleTTL 过期后仍然返回旧值的时间, 返回旧值的同时在后台刷新, 0 为不返回旧值
+	StaleTTL time.Duration
+	// LockTTL 加载时分布式锁的时间, 默认5秒
+	LockTTL time.Duration
+	// LockWait 没有获取到锁时等待其他实例写入缓存的时间, 默认为 LockTTL, 超时后自己加载
+	LockWait time.Duration
+	// LoadTimeout 加载的超时时间, 包含等待锁的时间, 默认为 LockWait+LockTTL
+	LoadTimeout time.Duration
+}
+
+// redisRememberValue 缓存的值
+type redisRememberValue struct {
+	// Value json序列化后的值
+	Value jsoniter.RawMessage `json:"v,omitempty"`
+	// NotFound 是否为空结果
+	NotFound bool `json:"n,omitempty"`
+	// ExpireAt 过期时间, 毫秒, 超过后为旧值
+	ExpireAt int64 `json:"e"`
+}
+
+// redisFlightCall 进程内正在进行的加载
+type redisFlightCall struct {
+	done chan struct{}
+	val  *redisRememberValue
+	err  error
+}
+
+// redisFlightGroup 合并进程内相同key的加载
+type redisFlightGroup struct {
+	mu sync.Mutex
+	m  map[string]*redisFlightCall
+}
+
+// redisFlight 进程内的加载
+var redisFlight = &redisFlightGroup{
+	m: map[string]*redisFlightCall{},
+}
+
+// do 相同key同时只执行一次 f, 所有调用等待并共享结果
+// f 在单独的协程中执行, 调用方的ctx结束时只停止等待, 不影响 f 和其他调用
+func (g *redisFlightGroup) do(ctx context.Context, key string, f func() (*redisRememberValue, error)) (*redisRememberValue, error) {
+	g.mu.Lock()
+	c, ok := g.m[key]
+	if !ok {
+		c = &redisFlightCall{
+			done: make(chan struct{}),
+		}
+		g.m[key] = c
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					c.err = fmt.Errorf("redis remember %s panic: %v", key, r)
+				}
+				g.mu.Lock()
+				delete(g.m, key)
+				g.mu.Unlock()
+				close(c.done)
+			}()
+			c.val, c.err = f()
+		}()
+	}
+	g.mu.Unlock()
+	select {
+	case <-c.done:
+		return c.val, c.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// redisDetachedCtx 保留ctx中的值, 不跟随ctx取消
+type redisDetachedCtx struct {
+	context.Context
+}
+
+// Deadline 没有截止时间
+func (redisDetachedCtx) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+// Done 不会结束
+func (redisDetachedCtx) Done() <-chan struct{} {
+	return nil
+}
+
+// Err 不会结束
+func (redisDetachedCtx) Err() error {
+	return nil
+}
+
+// Remember 从缓存获取并解析到 dest, 缓存不存在时调用 loader 加载并写入缓存
+// 进程内相同key只加载一次, 多个实例之间使用分布式锁只让一个实例加载
+// loader 的ctx保留调用方ctx中的值, 但不跟随调用方取消, 超时时间为 LoadTimeout
+// loader 返回 ErrRedisNotFound 时按 NegativeTTL 缓存空结果并返回 ErrRedisNotFound
+func (s *RedisStore) Remember(ctx context.Context, key string, ttl time.Duration, dest interface{}, loader func(ctx context.Context) (interface{}, error), opts *RedisRememberOptions) error {
+	if ttl <= 0 {
+		return fmt.Errorf("redis remember %s ttl error: %s", key, ttl)
+	}
+	if opts == nil {
+		opts = &RedisRememberOptions{}
+	}
+	cached, err := s.rememberGet(ctx, key)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	if cached != nil && cached.ExpireAt > now {
+		return redisRememberDecode(cached, dest)
+	}
+	load := func() (*redisRememberValue, error) {
+		loadCtx, cancel := context.WithTimeout(redisDetachedCtx{ctx}, opts.loadTimeout())
+		defer cancel()
+		return s.rememberLoad(loadCtx, key, ttl, loader, opts)
+	}
+	if cached != nil && opts.StaleTTL > 0 {
+		// 返回旧值, 后台刷新
+		go func() {
+			_, err := redisFlight.do(context.Background(), s.Key(key), load)
+			if err != nil && err != ErrRedisNotFound {
+				Log.Errorf("redis remember refresh %s error: %s", key, err.Error())
+			}
+		}()
+		return redisRememberDecode(cached, dest)
+	}
+	val, err := redisFlight.do(ctx, s.Key(key), load)
+	if err != nil {
+		return err
+	}
+	return redisRememberDecode(val, dest)
+}
+
+// lockTTL 加载时分布式锁的时间
+func (opts *RedisRememberOptions) lockTTL() time.Duration {
+	if opts.LockTTL <= 0 {
+		return 5 * time.Second
+	}
+	return opts.LockTTL
+}
+
+// lockWait 没有获取到锁时等待的时间
+func (opts *RedisRememberOptions) lockWait() time.Duration {
+	if opts.LockWait <= 0 {
+		return opts.lockTTL()
+	}
+	return opts.LockWait
+}
+
+// loadTimeout 加载的超时时间
+func (opts *RedisRememberOptions) loadTimeout() time.Duration {
+	if opts.LoadTimeout <= 0 {
+		return opts.lockWait() + opts.lockTTL()
+	}
+	return opts.LoadTimeout
+}
+
+// rememberGet 获取缓存, 不存在时返回nil
+func (s *RedisStore) rememberGet(ctx context.Context, key string) (*redisRememberValue, error) {
+	ret, err := s.cmd(ctx).Get(s.Key(key)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var val redisRememberValue
+	err = jsoniter.Unmarshal(ret, &val)
+	if err != nil {
+		// 格式不正确的缓存视为不存在
+		Log.Warnf("redis remember %s decode error: %s", key, err.Error())
+		return nil, nil
+	}
+	return &val, nil
+}
+
+// rememberLoad 获取分布式锁后加载并写入缓存
+func (s *RedisStore) rememberLoad(ctx context.Context, key string, ttl time.Duration, loader func(ctx context.Context) (interface{}, error), opts *RedisRememberOptions) (*redisRememberValue, error) {
+	lockWait := opts.lockWait()
+	lock := s.NewLock(key+"_remember_lock", opts.lockTTL())
+	ok, err := lock.TryLock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		defer func() {
+			err := lock.Unlock(context.Background())
+			if err != nil {
+				Log.Errorf("redis remember unlock %s error: %s", key, err.Error())
+			}
+		}()
+		// 锁丢失时其他实例可能同时在加载, 停止加载
+		var cancel context.CancelFunc
+		ctx, cancel = redisLockContext(ctx, lock)
+		defer cancel()
+	} else {
+		// 等待其他实例写入缓存
+		deadline := time.Now().Add(lockWait)
+		for time.Now().Before(deadline) {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(50 * time.Millisecond):
+			}
+			cached, err := s.rememberGet(ctx, key)
+			if err != nil {
+				return nil, err
+			}
+			if cached != nil && cached.ExpireAt > time.Now().UnixNano()/int64(time.Millisecond) {
+				return cached, nil
+			}
+		}
+	}
+
+	val := &redisRememberValue{}
+	ret, err := loader(ctx)
+	switch {
+	case err == ErrRedisNotFound:
+		val.NotFound = true
+		if opts.NegativeTTL <= 0 {
+			return val, nil
+		}
+		ttl = opts.NegativeTTL
+	case err != nil:
+		return nil, err
+	default:
+		val.Value, err = jsoniter.Marshal(ret)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.Jitter > 0 {
+		ttl += time.Duration(rand.Int63n(int64(float64(ttl)*opts.Jitter) + 1))
+	}
+	val.ExpireAt = time.Now().Add(ttl).UnixNano() / int64(time.Millisecond)
+	b, err := jsoniter.Marshal(val)
+	if err != nil {
+		return nil, err
+	}
+	err = s.cmd(ctx).Set(s.Key(key), b, ttl+opts.StaleTTL).Err()
+	if err != nil {
+		return nil, err
+	}
+	return val, nil
+}
+
+// redisRememberDecode 解析缓存的值
+func redisRememberDecode(val *redisRememberValue, dest interface{}) error {
+	if val.NotFound {
+		return ErrRedisNotFound
+	}
+	return jsoniter.Unmarshal(val.Value, dest)
+}
@@ -0,0 +1,164 @@
+package mcommon
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+func TestRedisRememberTTL(t *testing.T) {
+	s := RedisNewStore(nil, "app")
+	tests := []time.Duration{0, -time.Second}
+	for _, ttl := range tests {
+		var v int
+		err := s.Remember(context.Background(), "k", ttl, &v, func(ctx context.Context) (interface{}, error) {
+			t.Fatalf("loader called")
+			return nil, nil
+		}, nil)
+		if err == nil {
+			t.Errorf("ttl %s want error", ttl)
+		}
+	}
+}
+
+func TestRedisRemember(t *testing.T) {
+	server := redisNewTestServer(t)
+	defer server.Close()
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+	tests := []struct {
+		name      string
+		ret       interface{}
+		err       error
+		opts      *RedisRememberOptions
+		want      int
+		wantErr   error
+		wantLoads int32
+	}{
+		{"value", 3, nil, nil, 3, nil, 1},
+		{"not found", nil, ErrRedisNotFound, nil, 0, ErrRedisNotFound, 2},
+		{"not found cached", nil, ErrRedisNotFound, &RedisRememberOptions{NegativeTTL: time.Minute}, 0, ErrRedisNotFound, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := RedisNewStore(client, "remember_"+tt.name)
+			var loads int32
+			for i := 0; i < 2; i++ {
+				var v int
+				err := s.Remember(context.Background(), "k", time.Minute, &v, func(ctx context.Context) (interface{}, error) {
+					atomic.AddInt32(&loads, 1)
+					return tt.ret, tt.err
+				}, tt.opts)
+				if err != tt.wantErr {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				if v != tt.want {
+					t.Fatalf("v = %d, want %d", v, tt.want)
+				}
+			}
+			if loads != tt.wantLoads {
+				t.Fatalf("loads = %d, want %d", loads, tt.wantLoads)
+			}
+		})
+	}
+}
+
+func TestRedisRememberDetached(t *testing.T) {
+	server := redisNewTestServer(t)
+	defer server.Close()
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+	s := RedisNewStore(client, "app")
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), redisTestCtxKey{}, 1))
+	started := make(chan struct{})
+	release := make(chan struct{})
+	loaded := make(chan error, 1)
+	go func() {
+		<-started
+		cancel()
+	}()
+	var v int
+	err := s.Remember(ctx, "k", time.Minute, &v, func(loadCtx context.Context) (interface{}, error) {
+		close(started)
+		<-release
+		if loadCtx.Value(redisTestCtxKey{}) != 1 {
+			t.Errorf("loader ctx lost value")
+		}
+		loaded <- loadCtx.Err()
+		return 5, nil
+	}, nil)
+	if err != context.Canceled {
+		t.Fatalf("err = %v, want %v", err, context.Canceled)
+	}
+	close(release)
+	err = <-loaded
+	if err != nil {
+		t.Fatalf("loader ctx err = %v", err)
+	}
+
+	// 第一个调用方取消后加载仍然完成并写入缓存
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var v int
+			err := s.Remember(context.Background(), "k", time.Minute, &v, func(ctx context.Context) (interface{}, error) {
+				t.Errorf("loader called again")
+				return 0, nil
+			}, nil)
+			if err != nil || v != 5 {
+				t.Errorf("v = %d, err = %v", v, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRedisFlightGroup(t *testing.T) {
+	g := &redisFlightGroup{
+		m: map[string]*redisFlightCall{},
+	}
+	release := make(chan struct{})
+	var calls int32
+	f := func() (*redisRememberValue, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return &redisRememberValue{ExpireAt: 1}, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := g.do(ctx, "k", f)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("err = %v", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			val, err := g.do(context.Background(), "k", f)
+			if err != nil || val.ExpireAt != 1 {
+				t.Errorf("val = %v, err = %v", val, err)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Fatalf("calls = %d", calls)
+	}
+
+	_, err = g.do(context.Background(), "panic", func() (*redisRememberValue, error) {
+		panic("boom")
+	})
+	if err == nil {
+		t.Fatalf("want panic error")
+	}
+}
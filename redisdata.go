@@ -0,0 +1,489 @@
+package mcommon
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+	jsoniter "github.com/json-iterator/go"
+)
+
+// RedisZMember 有序集合成员
+type RedisZMember struct {
+	Member string
+	Score  float64
+	// Rank 排名, 从0开始
+	Rank int64
+}
+
+// GetJSON 获取并使用json解析到 dest, key不存在时返回false
+func (s *RedisStore) GetJSON(ctx context.Context, key string, dest interface{}) (bool, error) {
+	ret, err := s.cmd(ctx).Get(s.Key(key)).Bytes()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	err = jsoniter.Unmarshal(ret, dest)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SetJSON 使用json序列化后设置
+func (s *RedisStore) SetJSON(ctx context.Context, key string, value interface{}, du time.Duration) error {
+	b, err := jsoniter.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return s.cmd(ctx).Set(s.Key(key), b, du).Err()
+}
+
+// MGet 批量获取, 不存在的key不在结果中
+// 集群中的key可能不在同一个slot, 改为使用pipeline逐个获取
+func (s *RedisStore) MGet(ctx context.Context, keys ...string) (map[string]string, error) {
+	if len(keys) == 0 {
+		return map[string]string{}, nil
+	}
+	if _, ok := s.client.(*redis.ClusterClient); ok {
+		return s.mGetPipelined(ctx, keys)
+	}
+	fullKeys := make([]string, len(keys))
+	for i, key := range keys {
+		fullKeys[i] = s.Key(key)
+	}
+	rets, err := s.cmd(ctx).MGet(fullKeys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	for i, ret := range rets {
+		if v, ok := ret.(string); ok {
+			values[keys[i]] = v
+		}
+	}
+	return values, nil
+}
+
+// mGetPipelined 使用pipeline批量获取
+func (s *RedisStore) mGetPipelined(ctx context.Context, keys []string) (map[string]string, error) {
+	cmds := make([]*redis.StringCmd, len(keys))
+	_, err := s.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.Get(s.Key(key))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	for i, cmd := range cmds {
+		v, ok, err := redisStringResult(cmd)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			values[keys[i]] = v
+		}
+	}
+	return values, nil
+}
+
+// MSet 使用pipeline批量设置
+func (s *RedisStore) MSet(ctx context.Context, values map[string]string, du time.Duration) error {
+	_, err := s.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for key, value := range values {
+			pipe.Set(s.Key(key), value, du)
+		}
+		return nil
+	})
+	return err
+}
+
+// Pipelined 使用pipeline批量执行, 在 f 中需要使用 s.Key 获取带前缀的key
+func (s *RedisStore) Pipelined(ctx context.Context, f func(pipe redis.Pipeliner) error) ([]redis.Cmder, error) {
+	cmds, err := s.cmd(ctx).Pipelined(f)
+	if err == redis.Nil {
+		// 部分命令的key不存在
+		return cmds, nil
+	}
+	return cmds, err
+}
+
+// Incr 自增1
+func (s *RedisStore) Incr(ctx context.Context, key string) (int64, error) {
+	return s.cmd(ctx).Incr(s.Key(key)).Result()
+}
+
+// IncrBy 增加 n
+func (s *RedisStore) IncrBy(ctx context.Context, key string, n int64) (int64, error) {
+	return s.cmd(ctx).IncrBy(s.Key(key), n).Result()
+}
+
+// Expire 设置过期时间, key不存在时返回false
+func (s *RedisStore) Expire(ctx context.Context, key string, du time.Duration) (bool, error) {
+	return s.cmd(ctx).Expire(s.Key(key), du).Result()
+}
+
+// TTL 获取剩余过期时间, key不存在时返回 -2s, 没有过期时间时返回 -1s
+func (s *RedisStore) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return s.cmd(ctx).TTL(s.Key(key)).Result()
+}
+
+// Exists key是否存在
+func (s *RedisStore) Exists(ctx context.Context, key string) (bool, error) {
+	n, err := s.cmd(ctx).Exists(s.Key(key)).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// HGet 获取哈希字段, 不存在时返回空字符串
+func (s *RedisStore) HGet(ctx context.Context, key string, field string) (string, error) {
+	ret, err := s.cmd(ctx).HGet(s.Key(key), field).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return ret, nil
+}
+
+// HSet 设置哈希字段
+func (s *RedisStore) HSet(ctx context.Context, key string, field string, value interface{}) error {
+	return s.cmd(ctx).HSet(s.Key(key), field, value).Err()
+}
+
+// HMSet 设置多个哈希字段
+func (s *RedisStore) HMSet(ctx context.Context, key string, fields map[string]interface{}) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	return s.cmd(ctx).HMSet(s.Key(key), fields).Err()
+}
+
+// HGetAll 获取所有哈希字段
+func (s *RedisStore) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	return s.cmd(ctx).HGetAll(s.Key(key)).Result()
+}
+
+// HDel 删除哈希字段
+func (s *RedisStore) HDel(ctx context.Context, key string, fields ...string) error {
+	return s.cmd(ctx).HDel(s.Key(key), fields...).Err()
+}
+
+// HIncrBy 哈希字段增加 n
+func (s *RedisStore) HIncrBy(ctx context.Context, key string, field string, n int64) (int64, error) {
+	return s.cmd(ctx).HIncrBy(s.Key(key), field, n).Result()
+}
+
+// HSetStruct 按json标签将结构体字段保存为哈希字段, 非基础类型使用json序列化
+func (s *RedisStore) HSetStruct(ctx context.Context, key string, obj interface{}) error {
+	fields, err := redisStructToHash(obj)
+	if err != nil {
+		return err
+	}
+	return s.HMSet(ctx, key, fields)
+}
+
+// HGetAllStruct 获取所有哈希字段并按json标签填充结构体, key不存在时返回false
+func (s *RedisStore) HGetAllStruct(ctx context.Context, key string, dest interface{}) (bool, error) {
+	fields, err := s.HGetAll(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	if len(fields) == 0 {
+		return false, nil
+	}
+	err = redisHashToStruct(fields, dest)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ZAdd 添加有序集合成员
+func (s *RedisStore) ZAdd(ctx context.Context, key string, member string, score float64) error {
+	return s.cmd(ctx).ZAdd(s.Key(key), redis.Z{Score: score, Member: member}).Err()
+}
+
+// ZIncrBy 增加有序集合成员的分数
+func (s *RedisStore) ZIncrBy(ctx context.Context, key string, member string, n float64) (float64, error) {
+	return s.cmd(ctx).ZIncrBy(s.Key(key), n, member).Result()
+}
+
+// ZScore 获取成员的分数, 成员不存在时返回false
+func (s *RedisStore) ZScore(ctx context.Context, key string, member string) (float64, bool, error) {
+	score, err := s.cmd(ctx).ZScore(s.Key(key), member).Result()
+	if err == redis.Nil {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return score, true, nil
+}
+
+// ZRank 获取成员的排名, 从0开始, desc 为true时按分数从高到低, 成员不存在时返回false
+func (s *RedisStore) ZRank(ctx context.Context, key string, member string, desc bool) (int64, bool, error) {
+	var cmd *redis.IntCmd
+	if desc {
+		cmd = s.cmd(ctx).ZRevRank(s.Key(key), member)
+	} else {
+		cmd = s.cmd(ctx).ZRank(s.Key(key), member)
+	}
+	rank, err := cmd.Result()
+	if err == redis.Nil {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return rank, true, nil
+}
+
+// ZRange 获取排名在 [start, stop] 中的成员和分数, desc 为true时按分数从高到低
+func (s *RedisStore) ZRange(ctx context.Context, key string, start int64, stop int64, desc bool) ([]*RedisZMember, error) {
+	var cmd *redis.ZSliceCmd
+	if desc {
+		cmd = s.cmd(ctx).ZRevRangeWithScores(s.Key(key), start, stop)
+	} else {
+		cmd = s.cmd(ctx).ZRangeWithScores(s.Key(key), start, stop)
+	}
+	zs, err := cmd.Result()
+	if err != nil {
+		return nil, err
+	}
+	if start < 0 {
+		// 负数下标需要总数才能计算排名
+		count, err := s.cmd(ctx).ZCard(s.Key(key)).Result()
+		if err != nil {
+			return nil, err
+		}
+		start += count
+		if start < 0 {
+			start = 0
+		}
+	}
+	members := make([]*RedisZMember, len(zs))
+	for i, z := range zs {
+		members[i] = &RedisZMember{
+			Member: fmt.Sprint(z.Member),
+			Score:  z.Score,
+			Rank:   start + int64(i),
+		}
+	}
+	return members, nil
+}
+
+// ZRem 删除有序集合成员
+func (s *RedisStore) ZRem(ctx context.Context, key string, members ...string) error {
+	args := make([]interface{}, len(members))
+	for i, member := range members {
+		args[i] = member
+	}
+	return s.cmd(ctx).ZRem(s.Key(key), args...).Err()
+}
+
+// ZCard 获取有序集合成员数
+func (s *RedisStore) ZCard(ctx context.Context, key string) (int64, error) {
+	return s.cmd(ctx).ZCard(s.Key(key)).Result()
+}
+
+// LPush 从列表头部添加, 返回列表长度
+func (s *RedisStore) LPush(ctx context.Context, key string, values ...interface{}) (int64, error) {
+	return s.cmd(ctx).LPush(s.Key(key), values...).Result()
+}
+
+// RPush 从列表尾部添加, 返回列表长度
+func (s *RedisStore) RPush(ctx context.Context, key string, values ...interface{}) (int64, error) {
+	return s.cmd(ctx).RPush(s.Key(key), values...).Result()
+}
+
+// LPop 从列表头部取出, 列表为空时返回false
+func (s *RedisStore) LPop(ctx context.Context, key string) (string, bool, error) {
+	return redisStringResult(s.cmd(ctx).LPop(s.Key(key)))
+}
+
+// RPop 从列表尾部取出, 列表为空时返回false
+func (s *RedisStore) RPop(ctx context.Context, key string) (string, bool, error) {
+	return redisStringResult(s.cmd(ctx).RPop(s.Key(key)))
+}
+
+// LRange 获取列表中下标在 [start, stop] 中的元素
+func (s *RedisStore) LRange(ctx context.Context, key string, start int64, stop int64) ([]string, error) {
+	return s.cmd(ctx).LRange(s.Key(key), start, stop).Result()
+}
+
+// LLen 获取列表长度
+func (s *RedisStore) LLen(ctx context.Context, key string) (int64, error) {
+	return s.cmd(ctx).LLen(s.Key(key)).Result()
+}
+
+// LTrim 只保留列表中下标在 [start, stop] 中的元素
+func (s *RedisStore) LTrim(ctx context.Context, key string, start int64, stop int64) error {
+	return s.cmd(ctx).LTrim(s.Key(key), start, stop).Err()
+}
+
+// SAdd 添加集合成员, 返回新添加的个数
+func (s *RedisStore) SAdd(ctx context.Context, key string, members ...interface{}) (int64, error) {
+	return s.cmd(ctx).SAdd(s.Key(key), members...).Result()
+}
+
+// SRem 删除集合成员
+func (s *RedisStore) SRem(ctx context.Context, key string, members ...interface{}) error {
+	return s.cmd(ctx).SRem(s.Key(key), members...).Err()
+}
+
+// SMembers 获取所有集合成员
+func (s *RedisStore) SMembers(ctx context.Context, key string) ([]string, error) {
+	return s.cmd(ctx).SMembers(s.Key(key)).Result()
+}
+
+// SIsMember 是否是集合成员
+func (s *RedisStore) SIsMember(ctx context.Context, key string, member interface{}) (bool, error) {
+	return s.cmd(ctx).SIsMember(s.Key(key), member).Result()
+}
+
+// SCard 获取集合成员数
+func (s *RedisStore) SCard(ctx context.Context, key string) (int64, error) {
+	return s.cmd(ctx).SCard(s.Key(key)).Result()
+}
+
+// redisStringResult 获取字符串结果, 不存在时返回false
+func redisStringResult(cmd *redis.StringCmd) (string, bool, error) {
+	ret, err := cmd.Result()
+	if err == redis.Nil {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return ret, true, nil
+}
+
+// redisStructFields 获取结构体字段, 字段名使用json标签, 展开匿名结构体
+func redisStructFields(t reflect.Type, index []int, f func(name string, index []int)) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		fieldIndex := append(append([]int{}, index...), i)
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			redisStructFields(field.Type, fieldIndex, f)
+			continue
+		}
+		if field.PkgPath != "" {
+			// 未导出字段
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		f(name, fieldIndex)
+	}
+}
+
+// redisStructToHash 结构体转换为哈希字段
+func redisStructToHash(obj interface{}) (map[string]interface{}, error) {
+	v := reflect.Indirect(reflect.ValueOf(obj))
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("redis hash need struct, got %T", obj)
+	}
+	fields := map[string]interface{}{}
+	var err error
+	redisStructFields(v.Type(), nil, func(name string, index []int) {
+		if err != nil {
+			return
+		}
+		fv := v.FieldByIndex(index)
+		if fv.Kind() == reflect.Ptr {
+			if fv.IsNil() {
+				return
+			}
+			fv = fv.Elem()
+		}
+		switch fv.Kind() {
+		case reflect.String:
+			fields[name] = fv.String()
+		case reflect.Bool:
+			fields[name] = strconv.FormatBool(fv.Bool())
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			fields[name] = strconv.FormatInt(fv.Int(), 10)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			fields[name] = strconv.FormatUint(fv.Uint(), 10)
+		case reflect.Float32, reflect.Float64:
+			fields[name] = strconv.FormatFloat(fv.Float(), 'f', -1, 64)
+		default:
+			var b []byte
+			b, err = jsoniter.Marshal(fv.Interface())
+			fields[name] = string(b)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// redisHashToStruct 哈希字段填充结构体
+func redisHashToStruct(fields map[string]string, dest interface{}) error {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("redis hash need struct pointer, got %T", dest)
+	}
+	v = v.Elem()
+	var err error
+	redisStructFields(v.Type(), nil, func(name string, index []int) {
+		if err != nil {
+			return
+		}
+		s, ok := fields[name]
+		if !ok {
+			return
+		}
+		fv := v.FieldByIndex(index)
+		if fv.Kind() == reflect.Ptr {
+			fv.Set(reflect.New(fv.Type().Elem()))
+			fv = fv.Elem()
+		}
+		switch fv.Kind() {
+		case reflect.String:
+			fv.SetString(s)
+		case reflect.Bool:
+			var b bool
+			b, err = strconv.ParseBool(s)
+			fv.SetBool(b)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			var n int64
+			n, err = strconv.ParseInt(s, 10, 64)
+			fv.SetInt(n)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			var n uint64
+			n, err = strconv.ParseUint(s, 10, 64)
+			fv.SetUint(n)
+		case reflect.Float32, reflect.Float64:
+			var n float64
+			n, err = strconv.ParseFloat(s, 64)
+			fv.SetFloat(n)
+		default:
+			err = jsoniter.Unmarshal([]byte(s), fv.Addr().Interface())
+		}
+		if err != nil {
+			err = fmt.Errorf("redis hash field %s: %w", name, err)
+		}
+	})
+	return err
+}
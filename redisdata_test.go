@@ -0,0 +1,153 @@
+package mcommon
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/go-redis/redis"
+)
+
+func TestRedisStoreMGet(t *testing.T) {
+	server := redisNewTestServer(t)
+	defer server.Close()
+	tests := []struct {
+		name     string
+		client   func() redis.UniversalClient
+		wantCmds []string
+	}{
+		{
+			name: "client",
+			client: func() redis.UniversalClient {
+				return redis.NewClient(&redis.Options{Addr: server.Addr()})
+			},
+			wantCmds: []string{"MGET"},
+		},
+		{
+			name: "cluster",
+			client: func() redis.UniversalClient {
+				return redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{server.Addr()}})
+			},
+			wantCmds: []string{"GET", "GET", "GET"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := tt.client()
+			defer client.Close()
+			s := RedisNewStore(client, "app")
+			ctx := context.Background()
+			err := s.MSet(ctx, map[string]string{"a": "1", "b": "2"}, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			start := len(server.Cmds())
+			values, err := s.MGet(ctx, "a", "b", "c")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(values, map[string]string{"a": "1", "b": "2"}) {
+				t.Fatalf("values = %v", values)
+			}
+			var cmds []string
+			for _, cmd := range server.Cmds()[start:] {
+				if cmd != "CLUSTER" && cmd != "COMMAND" {
+					cmds = append(cmds, cmd)
+				}
+			}
+			if !reflect.DeepEqual(cmds, tt.wantCmds) {
+				t.Fatalf("cmds = %v, want %v", cmds, tt.wantCmds)
+			}
+			values, err = s.MGet(ctx)
+			if err != nil || len(values) != 0 {
+				t.Fatalf("empty values = %v, err = %v", values, err)
+			}
+		})
+	}
+}
+
+// redisTestBase 测试匿名字段
+type redisTestBase struct {
+	ID int64 `json:"id"`
+}
+
+// redisTestObj 测试哈希结构体
+type redisTestObj struct {
+	redisTestBase
+	Name    string            `json:"name"`
+	Enabled bool              `json:"enabled"`
+	Score   float64           `json:"score"`
+	Count   uint32            `json:"count"`
+	Tags    []string          `json:"tags"`
+	Ext     map[string]string `json:"ext"`
+	Ptr     *int64            `json:"ptr"`
+	Skip    string            `json:"-"`
+	NoTag   string
+	private string
+}
+
+func TestRedisStructHash(t *testing.T) {
+	n := int64(5)
+	obj := redisTestObj{
+		redisTestBase: redisTestBase{ID: 1},
+		Name:          "a",
+		Enabled:       true,
+		Score:         1.5,
+		Count:         3,
+		Tags:          []string{"x"},
+		Ptr:           &n,
+		Skip:          "skip",
+		NoTag:         "b",
+		private:       "p",
+	}
+	fields, err := redisStructToHash(&obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"id":      "1",
+		"name":    "a",
+		"enabled": "true",
+		"score":   "1.5",
+		"count":   "3",
+		"tags":    `["x"]`,
+		"ext":     "null",
+		"ptr":     "5",
+		"NoTag":   "b",
+	}
+	if !reflect.DeepEqual(fields, want) {
+		t.Fatalf("fields = %v, want %v", fields, want)
+	}
+	hash := map[string]string{}
+	for k, v := range fields {
+		hash[k] = v.(string)
+	}
+	var got redisTestObj
+	err = redisHashToStruct(hash, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj.Skip = ""
+	obj.private = ""
+	if !reflect.DeepEqual(got, obj) {
+		t.Fatalf("obj = %+v, want %+v", got, obj)
+	}
+
+	tests := []struct {
+		name   string
+		fields map[string]string
+		dest   interface{}
+	}{
+		{"bad int", map[string]string{"id": "x"}, &redisTestObj{}},
+		{"bad json", map[string]string{"tags": "x"}, &redisTestObj{}},
+		{"not pointer", map[string]string{}, redisTestObj{}},
+	}
+	for _, tt := range tests {
+		if err := redisHashToStruct(tt.fields, tt.dest); err == nil {
+			t.Errorf("%s want error", tt.name)
+		}
+	}
+	if _, err := redisStructToHash(1); err == nil {
+		t.Errorf("not struct want error")
+	}
+}
@@ -0,0 +1,229 @@
+package mcommon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// ErrRedisLockNotHeld 锁不存在或者已经被其他人持有
+var ErrRedisLockNotHeld = errors.New("redis lock not held")
+
+// redisUnlockScript token一致时删除锁
+var redisUnlockScript = redis.NewScript(`if redis.call("GET", KEYS[1]) == ARGV[1] then
+    return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// redisRenewScript token一致时延长锁
+var redisRenewScript = redis.NewScript(`if redis.call("GET", KEYS[1]) == ARGV[1] then
+    return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// RedisLock redis分布式锁, 使用随机token保证只释放自己的锁
+// 持有期间默认后台自动续期, 续期发现锁丢失时关闭 Lost, 不能在多个协程中同时使用同一个对象
+type RedisLock struct {
+	store         *RedisStore
+	key           string
+	ttl           time.Duration
+	retryInterval time.Duration
+	watchdog      bool
+
+	mu    sync.Mutex
+	token string
+	stop  chan struct{}
+	done  chan struct{}
+	lost  chan struct{}
+}
+
+// NewLock 创建锁, ttl 为锁的过期时间, 持有期间每 ttl/3 自动续期
+func (s *RedisStore) NewLock(key string, ttl time.Duration) *RedisLock {
+	return &RedisLock{
+		store:         s,
+		key:           key,
+		ttl:           ttl,
+		retryInterval: 100 * time.Millisecond,
+		watchdog:      true,
+	}
+}
+
+// WithoutWatchdog 不自动续期, 到期后锁自动释放
+func (l *RedisLock) WithoutWatchdog() *RedisLock {
+	l.watchdog = false
+	return l
+}
+
+// WithRetryInterval 设置 Lock 重试获取的间隔
+func (l *RedisLock) WithRetryInterval(du time.Duration) *RedisLock {
+	l.retryInterval = du
+	return l
+}
+
+// Key 获取带前缀的锁key
+func (l *RedisLock) Key() string {
+	return l.store.Key(l.key)
+}
+
+// TryLock 尝试获取锁, 已经被其他人持有时返回false, ttl 不能小于1毫秒
+func (l *RedisLock) TryLock(ctx context.Context) (bool, error) {
+	if l.ttl < time.Millisecond {
+		// 没有过期时间的锁不会自动释放, 续期时 PEXPIRE 0 会直接删除锁
+		return false, fmt.Errorf("redis lock %s ttl error: %s", l.key, l.ttl)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.token != "" {
+		return false, errors.New("redis lock already held by self")
+	}
+	token := GetUUIDStr()
+	ok, err := l.store.cmd(ctx).SetNX(l.Key(), token, l.ttl).Result()
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		return false, nil
+	}
+	l.token = token
+	l.lost = make(chan struct{})
+	if l.watchdog {
+		l.stop = make(chan struct{})
+		l.done = make(chan struct{})
+		go l.renew(token, l.stop, l.done, l.lost)
+	}
+	return true, nil
+}
+
+// Lost 获取锁丢失的通知, 自动续期时发现锁已过期或被其他人持有时关闭
+// 没有自动续期时不会关闭, 没有持有锁时返回nil
+func (l *RedisLock) Lost() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lost
+}
+
+// Lock 获取锁, 已经被其他人持有时等待, 直到获取成功或者ctx结束
+func (l *RedisLock) Lock(ctx context.Context) error {
+	for {
+		ok, err := l.TryLock(ctx)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(l.retryInterval):
+		}
+	}
+}
+
+// Unlock 释放锁, 锁已经过期或者被其他人持有时返回 ErrRedisLockNotHeld
+func (l *RedisLock) Unlock(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.token == "" {
+		return ErrRedisLockNotHeld
+	}
+	if l.stop != nil {
+		close(l.stop)
+		<-l.done
+		l.stop = nil
+		l.done = nil
+	}
+	token := l.token
+	l.token = ""
+	l.lost = nil
+	n, err := redisUnlockScript.Run(l.store.cmd(ctx), []string{l.Key()}, token).Int64()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrRedisLockNotHeld
+	}
+	return nil
+}
+
+// renew 持有期间定时续期, 锁丢失或者超过 ttl 没有续期成功时关闭 lost 并停止
+func (l *RedisLock) renew(token string, stop chan struct{}, done chan struct{}, lost chan struct{}) {
+	defer close(done)
+	interval := l.ttl / 3
+	if interval <= 0 {
+		interval = time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	lastRenew := time.Now()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		n, err := redisRenewScript.Run(l.store.cmd(ctx), []string{l.Key()}, token, int64(l.ttl/time.Millisecond)).Int64()
+		cancel()
+		if err != nil {
+			Log.Errorf("redis lock %s renew error: %s", l.key, err.Error())
+			if time.Since(lastRenew) < l.ttl {
+				continue
+			}
+			n = 0
+		}
+		if n == 0 {
+			Log.Warnf("redis lock %s lost", l.key)
+			close(lost)
+			return
+		}
+		lastRenew = time.Now()
+	}
+}
+
+// redisLockContext 获取锁丢失时取消的ctx
+func redisLockContext(ctx context.Context, lock *RedisLock) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	lost := lock.Lost()
+	go func() {
+		select {
+		case <-lost:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// WithLock 获取锁后执行 f, 执行完成后释放锁
+// 锁丢失时取消传给 f 的ctx, f 没有返回错误时返回 ErrRedisLockNotHeld
+func (s *RedisStore) WithLock(ctx context.Context, key string, ttl time.Duration, f func(ctx context.Context) error) error {
+	lock := s.NewLock(key, ttl)
+	err := lock.Lock(ctx)
+	if err != nil {
+		return err
+	}
+	lost := lock.Lost()
+	defer func() {
+		err := lock.Unlock(context.Background())
+		if err != nil {
+			Log.Errorf("redis lock %s unlock error: %s", key, err.Error())
+		}
+	}()
+	lockCtx, cancel := redisLockContext(ctx, lock)
+	defer cancel()
+	err = f(lockCtx)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-lost:
+		return ErrRedisLockNotHeld
+	default:
+	}
+	return nil
+}
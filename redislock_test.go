@@ -0,0 +1,151 @@
+package mcommon
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+func TestRedisLock(t *testing.T) {
+	server := redisNewTestServer(t)
+	defer server.Close()
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+	s := RedisNewStore(client, "app")
+	ctx := context.Background()
+
+	lock := s.NewLock("lock", time.Second)
+	if lock.Lost() != nil {
+		t.Fatalf("lost before lock")
+	}
+	ok, err := lock.TryLock(ctx)
+	if err != nil || !ok {
+		t.Fatalf("ok = %v, err = %v", ok, err)
+	}
+	ok, err = s.NewLock("lock", time.Second).TryLock(ctx)
+	if err != nil || ok {
+		t.Fatalf("other ok = %v, err = %v", ok, err)
+	}
+	_, err = lock.TryLock(ctx)
+	if err == nil {
+		t.Fatalf("want held by self error")
+	}
+	err = lock.Unlock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lock.Lost() != nil {
+		t.Fatalf("lost after unlock")
+	}
+	err = lock.Unlock(ctx)
+	if err != ErrRedisLockNotHeld {
+		t.Fatalf("err = %v, want %v", err, ErrRedisLockNotHeld)
+	}
+}
+
+func TestRedisLockLost(t *testing.T) {
+	server := redisNewTestServer(t)
+	defer server.Close()
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+	s := RedisNewStore(client, "app")
+	ctx := context.Background()
+	tests := []struct {
+		name     string
+		watchdog bool
+		wantLost bool
+	}{
+		{"watchdog", true, true},
+		{"without watchdog", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lock := s.NewLock("lost", 30*time.Millisecond)
+			if !tt.watchdog {
+				lock.WithoutWatchdog()
+			}
+			err := lock.Lock(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			server.Del(lock.Key())
+			select {
+			case <-lock.Lost():
+				if !tt.wantLost {
+					t.Fatalf("lost closed")
+				}
+			case <-time.After(200 * time.Millisecond):
+				if tt.wantLost {
+					t.Fatalf("lost not closed")
+				}
+			}
+			err = lock.Unlock(ctx)
+			if err != ErrRedisLockNotHeld {
+				t.Fatalf("err = %v, want %v", err, ErrRedisLockNotHeld)
+			}
+		})
+	}
+}
+
+func TestRedisWithLock(t *testing.T) {
+	server := redisNewTestServer(t)
+	defer server.Close()
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+	s := RedisNewStore(client, "app")
+	tests := []struct {
+		name    string
+		lose    bool
+		wantErr error
+	}{
+		{"ok", false, nil},
+		{"lost", true, ErrRedisLockNotHeld},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.WithLock(context.Background(), "with_lock", 30*time.Millisecond, func(ctx context.Context) error {
+				if tt.lose {
+					server.Del(s.Key("with_lock"))
+				}
+				select {
+				case <-ctx.Done():
+					if !tt.lose {
+						t.Errorf("ctx canceled: %v", ctx.Err())
+					}
+				case <-time.After(100 * time.Millisecond):
+					if tt.lose {
+						t.Errorf("ctx not canceled")
+					}
+				}
+				return nil
+			})
+			if err != tt.wantErr {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRedisLockTTL(t *testing.T) {
+	s := RedisNewStore(nil, "app")
+	tests := []time.Duration{0, -time.Second, time.Microsecond}
+	for _, ttl := range tests {
+		ok, err := s.NewLock("lock", ttl).TryLock(context.Background())
+		if err == nil || ok {
+			t.Errorf("ttl %s try lock ok = %v, err = %v", ttl, ok, err)
+		}
+		err = s.NewLock("lock", ttl).WithoutWatchdog().Lock(context.Background())
+		if err == nil {
+			t.Errorf("ttl %s lock want error", ttl)
+		}
+		err = s.WithLock(context.Background(), "lock", ttl, func(ctx context.Context) error {
+			t.Errorf("ttl %s f called", ttl)
+			return nil
+		})
+		if err == nil {
+			t.Errorf("ttl %s with lock want error", ttl)
+		}
+	}
+}
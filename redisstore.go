@@ -0,0 +1,85 @@
+package mcommon
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// RedisStore 带key前缀的redis客户端, 支持单机, 哨兵和集群
+type RedisStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// RedisCreateUniversal 创建redis客户端
+// 设置 MasterName 时使用哨兵, 多个地址时使用集群, 否则使用单机
+func RedisCreateUniversal(opts *redis.UniversalOptions) redis.UniversalClient {
+	client := redis.NewUniversalClient(opts)
+	_, err := client.Ping().Result()
+	if err != nil {
+		Log.Fatalf("redis ping error: %s", err.Error())
+		return nil
+	}
+	return client
+}
+
+// RedisNewStore 创建带前缀的redis客户端, 前缀为空时不添加前缀
+func RedisNewStore(client redis.UniversalClient, prefix string) *RedisStore {
+	return &RedisStore{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+// Client 获取redis客户端
+func (s *RedisStore) Client() redis.UniversalClient {
+	return s.client
+}
+
+// Prefix 获取前缀
+func (s *RedisStore) Prefix() string {
+	return s.prefix
+}
+
+// Key 获取带前缀的key
+func (s *RedisStore) Key(key string) string {
+	if s.prefix == "" {
+		return key
+	}
+	return s.prefix + "_" + key
+}
+
+// Get 获取, key不存在时返回空字符串
+func (s *RedisStore) Get(ctx context.Context, key string) (string, error) {
+	ret, err := s.cmd(ctx).Get(s.Key(key)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return ret, nil
+}
+
+// Set 设置
+func (s *RedisStore) Set(ctx context.Context, key, value string, du time.Duration) error {
+	return s.cmd(ctx).Set(s.Key(key), value, du).Err()
+}
+
+// Rm 删除
+func (s *RedisStore) Rm(ctx context.Context, key string) error {
+	return s.cmd(ctx).Del(s.Key(key)).Err()
+}
+
+// cmd 获取绑定ctx的客户端, 不支持ctx的客户端原样返回
+func (s *RedisStore) cmd(ctx context.Context) redis.UniversalClient {
+	switch client := s.client.(type) {
+	case *redis.Client:
+		return client.WithContext(ctx)
+	case *redis.ClusterClient:
+		return client.WithContext(ctx)
+	}
+	return s.client
+}
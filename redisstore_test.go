@@ -0,0 +1,234 @@
+package mcommon
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-redis/redis"
+)
+
+// redisTestCtxKey 测试上下文key
+type redisTestCtxKey struct{}
+
+func TestRedisStoreKey(t *testing.T) {
+	tests := []struct {
+		prefix string
+		key    string
+		want   string
+	}{
+		{"", "a", "a"},
+		{"app", "a", "app_a"},
+		{"app", "", "app_"},
+		{"app_v2", "user:1", "app_v2_user:1"},
+	}
+	for _, tt := range tests {
+		s := RedisNewStore(nil, tt.prefix)
+		got := s.Key(tt.key)
+		if got != tt.want {
+			t.Errorf("prefix %q key %q = %q, want %q", tt.prefix, tt.key, got, tt.want)
+		}
+		if s.Prefix() != tt.prefix {
+			t.Errorf("prefix = %q, want %q", s.Prefix(), tt.prefix)
+		}
+	}
+}
+
+func TestRedisStoreCmd(t *testing.T) {
+	ctx := context.WithValue(context.Background(), redisTestCtxKey{}, 1)
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:0"})
+	defer client.Close()
+	cluster := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{"127.0.0.1:0"}})
+	defer cluster.Close()
+	tests := []struct {
+		name   string
+		client redis.UniversalClient
+	}{
+		{"client", client},
+		{"cluster", cluster},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := RedisNewStore(tt.client, "app").cmd(ctx)
+			var got context.Context
+			switch c := cmd.(type) {
+			case *redis.Client:
+				got = c.Context()
+			case *redis.ClusterClient:
+				got = c.Context()
+			}
+			if got != ctx {
+				t.Fatalf("cmd not bound to ctx")
+			}
+		})
+	}
+}
+
+// redisTestServer 测试用的redis服务, 支持 PING, GET, SET, DEL, MGET, COMMAND, CLUSTER SLOTS 和锁的脚本, 记录收到的命令
+// 不处理过期时间
+type redisTestServer struct {
+	ln   net.Listener
+	mu   sync.Mutex
+	data map[string]string
+	cmds []string
+}
+
+// redisNewTestServer 启动测试用的redis服务
+func redisNewTestServer(t *testing.T) *redisTestServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &redisTestServer{
+		ln:   ln,
+		data: map[string]string{},
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+// Addr 服务地址
+func (s *redisTestServer) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// Close 关闭服务
+func (s *redisTestServer) Close() {
+	_ = s.ln.Close()
+}
+
+// Del 删除key
+func (s *redisTestServer) Del(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data, key)
+}
+
+// Cmds 收到的命令名
+func (s *redisTestServer) Cmds() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.cmds...)
+}
+
+// serve 处理链接
+func (s *redisTestServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := redisTestReadArgs(r)
+		if err != nil {
+			return
+		}
+		_, err = conn.Write([]byte(s.handle(args)))
+		if err != nil {
+			return
+		}
+	}
+}
+
+// handle 执行命令, 返回RESP格式的结果
+func (s *redisTestServer) handle(args []string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := strings.ToUpper(args[0])
+	s.cmds = append(s.cmds, name)
+	bulk := func(v string, ok bool) string {
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+	}
+	switch name {
+	case "PING":
+		return "+PONG\r\n"
+	case "GET":
+		v, ok := s.data[args[1]]
+		return bulk(v, ok)
+	case "SET":
+		for _, arg := range args[3:] {
+			if _, ok := s.data[args[1]]; ok && strings.ToUpper(arg) == "NX" {
+				return "$-1\r\n"
+			}
+		}
+		s.data[args[1]] = args[2]
+		return "+OK\r\n"
+	case "EVALSHA":
+		return "-NOSCRIPT No matching script.\r\n"
+	case "EVAL":
+		// 只支持锁的解锁和续期脚本
+		if s.data[args[3]] != args[4] {
+			return ":0\r\n"
+		}
+		if !strings.Contains(args[1], "PEXPIRE") {
+			delete(s.data, args[3])
+		}
+		return ":1\r\n"
+	case "DEL":
+		n := 0
+		for _, key := range args[1:] {
+			if _, ok := s.data[key]; ok {
+				delete(s.data, key)
+				n++
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", n)
+	case "MGET":
+		ret := fmt.Sprintf("*%d\r\n", len(args)-1)
+		for _, key := range args[1:] {
+			v, ok := s.data[key]
+			ret += bulk(v, ok)
+		}
+		return ret
+	case "COMMAND":
+		return "*0\r\n"
+	case "CLUSTER":
+		host, port, _ := net.SplitHostPort(s.ln.Addr().String())
+		return fmt.Sprintf("*1\r\n*3\r\n:0\r\n:16383\r\n*2\r\n%s:%s\r\n", bulk(host, true), port)
+	}
+	return "-ERR unknown command '" + name + "'\r\n"
+}
+
+// redisTestReadArgs 读取RESP格式的命令
+func redisTestReadArgs(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err = r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		l, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, l+2)
+		_, err = io.ReadFull(r, buf)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:l])
+	}
+	return args, nil
+}
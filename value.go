@@ -1,5 +1,11 @@
 package mcommon
 
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+)
+
 // 数据库数据类型
 const (
 	MySqlGoTypeString  = 1
@@ -7,6 +13,7 @@ const (
 	MySqlGoTypeBytes   = 3
 	MySqlGoTypeFloat64 = 4
 	MySqlGoTypeTime    = 5
+	MySqlGoTypeUint64  = 6
 )
 
 // MysqlTypeToGoMap 类型转换关系
@@ -41,4 +48,46 @@ var MysqlTypeToGoMap = map[string]int64{
 	"VARBINARY":  3,
 	"VARCHAR":    1,
 	"YEAR":       2,
+
+	"UNSIGNED TINYINT":   2,
+	"UNSIGNED SMALLINT":  2,
+	"UNSIGNED MEDIUMINT": 2,
+	"UNSIGNED INT":       2,
+	"UNSIGNED BIGINT":    6,
+	"UNSIGNED DECIMAL":   1,
+	"UNSIGNED FLOAT":     4,
+	"UNSIGNED DOUBLE":    4,
+}
+
+// DbTypeConverter 数据库类型转换函数, v 为数据库返回的原始值, 不会为NULL
+type DbTypeConverter func(v []byte) (interface{}, error)
+
+// dbTypeConverters 自定义类型转换, 默认 DECIMAL 转换为 json.Number 保留精度
+var dbTypeConverters = map[string]DbTypeConverter{
+	"DECIMAL":          dbDecimalConverter,
+	"UNSIGNED DECIMAL": dbDecimalConverter,
+}
+
+// dbDecimalConverter DECIMAL 转换为 json.Number
+func dbDecimalConverter(v []byte) (interface{}, error) {
+	return json.Number(v), nil
+}
+
+// dbTypeConvertersLock 自定义类型转换锁
+var dbTypeConvertersLock sync.RWMutex
+
+// DbRegisterTypeConverter 注册数据库类型转换, 优先于 MysqlTypeToGoMap 使用
+// dbType 为驱动返回的类型名, 如 VECTOR
+func DbRegisterTypeConverter(dbType string, f DbTypeConverter) {
+	dbTypeConvertersLock.Lock()
+	defer dbTypeConvertersLock.Unlock()
+	dbTypeConverters[strings.ToUpper(dbType)] = f
+}
+
+// dbGetTypeConverter 获取自定义类型转换
+func dbGetTypeConverter(dbType string) (DbTypeConverter, bool) {
+	dbTypeConvertersLock.RLock()
+	defer dbTypeConvertersLock.RUnlock()
+	f, ok := dbTypeConverters[dbType]
+	return f, ok
 }
@@ -0,0 +1,77 @@
+package mcommon
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestDbTypeConverter(t *testing.T) {
+	tests := []struct {
+		dbType string
+		raw    string
+		want   interface{}
+	}{
+		{"DECIMAL", "12.3400", json.Number("12.3400")},
+		{"UNSIGNED DECIMAL", "0.01", json.Number("0.01")},
+	}
+	for _, tt := range tests {
+		if MysqlTypeToGoMap[tt.dbType] != MySqlGoTypeString {
+			t.Errorf("MysqlTypeToGoMap[%s] = %d, want %d", tt.dbType, MysqlTypeToGoMap[tt.dbType], MySqlGoTypeString)
+		}
+		f, ok := dbGetTypeConverter(tt.dbType)
+		if !ok {
+			t.Fatalf("no converter for %s", tt.dbType)
+		}
+		got, err := f([]byte(tt.raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("convert %s %s = %#v, want %#v", tt.dbType, tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestDbNamedRowsContentNull(t *testing.T) {
+	f := DbNewFake()
+	defer f.Close()
+	f.Expect(`^SELECT`).WillReturnRows(
+		[]string{"id", "name", "price", "at"},
+		[]interface{}{int64(1), nil, nil, nil},
+		[]interface{}{nil, "a", 1.5, nil},
+	)
+	rows, err := DbNamedRowsContent(context.Background(), f.DB, "SELECT id, name, price, at FROM t", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string]interface{}{
+		{"id": int64(1), "name": nil, "price": nil, "at": nil},
+		{"id": nil, "name": "a", "price": 1.5, "at": nil},
+	}
+	for i := range want {
+		if !reflect.DeepEqual(map[string]interface{}(rows[i]), want[i]) {
+			t.Fatalf("row %d = %#v, want %#v", i, rows[i], want[i])
+		}
+	}
+}
+
+func TestDbRegisterTypeConverter(t *testing.T) {
+	DbRegisterTypeConverter("vector_test", func(v []byte) (interface{}, error) {
+		return len(v), nil
+	})
+	defer func() {
+		dbTypeConvertersLock.Lock()
+		delete(dbTypeConverters, "VECTOR_TEST")
+		dbTypeConvertersLock.Unlock()
+	}()
+	f, ok := dbGetTypeConverter("VECTOR_TEST")
+	if !ok {
+		t.Fatalf("converter not registered")
+	}
+	got, _ := f([]byte("abc"))
+	if got != 3 {
+		t.Fatalf("convert = %v, want 3", got)
+	}
+}